@@ -0,0 +1,66 @@
+package main
+
+import (
+	"auth-service/data"
+	"auth-service/event"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// publishAuthEvent emits an auth.* event for the listener to audit and act on.
+// Publishing is best effort: a RabbitMQ outage must not stop people logging in.
+func (app *Config) publishAuthEvent(r *http.Request, name, severity, message string, user *data.User, fields map[string]any) {
+	if app.Emitter == nil {
+		return
+	}
+
+	if fields == nil {
+		fields = map[string]any{}
+	}
+
+	fields["ip"] = clientIP(r)
+	fields["user_agent"] = r.UserAgent()
+
+	payload := event.Payload{
+		Name:     name,
+		Data:     message,
+		Severity: severity,
+		Service:  "auth-service",
+		Fields:   fields,
+	}
+
+	if user != nil {
+		payload.UserID = int64(user.ID)
+		fields["email"] = user.Email
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("could not encode auth event:", err)
+		return
+	}
+
+	err = app.Emitter.Push(string(jsonData), event.RoutingKey(event.FamilyAuth, severity))
+	if err != nil {
+		log.Println("could not publish auth event:", err)
+	}
+}
+
+// clientIP returns the address of the original caller, trusting the first
+// X-Forwarded-For entry set by the broker
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		ip, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(ip)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"auth-service/data"
+	"auth-service/event"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 )
 
+type authResponse struct {
+	User         *data.User  `json:"user"`
+	AccessToken  issuedToken `json:"access_token"`
+	RefreshToken issuedToken `json:"refresh_token"`
+}
+
 func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email    string `json:"email"`
@@ -25,58 +31,182 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	user, err := app.Models.User.GetByEmail(requestPayload.Email)
 
 	if err != nil {
+		app.publishAuthEvent(r, event.LoginFailure, event.SeverityWarning, "Login attempt for unknown email", nil,
+			map[string]any{"reason": "unknown_email", "email": requestPayload.Email})
 		app.errorJSON(w, errors.New("invalid creds"), http.StatusUnauthorized)
 		return
 	}
 
-	// valid, err := app.Models.User.PasswordMatches(requestPayload.Password)
+	valid, err := user.PasswordMatches(requestPayload.Password)
 
-	// if err != nil || !valid {
-	// 	app.errorJSON(w, errors.New("invalid creds"), http.StatusUnauthorized)
-	// 	return
-	// }
+	// the password is checked even for a locked account, and either way the answer
+	// is the same, so the reply doesn't tell which addresses exist and are locked
+	if user.Locked() {
+		app.publishAuthEvent(r, event.LoginFailure, event.SeverityWarning, fmt.Sprintf("Login attempt for locked user %s", user.Email), user,
+			map[string]any{"reason": "locked"})
+		app.errorJSON(w, errors.New("invalid creds"), http.StatusUnauthorized)
+		return
+	}
 
-	err = app.LogItem("Authenticated!", fmt.Sprintf("User %s logged in", user.Email))
+	if err != nil || !valid {
+		app.publishAuthEvent(r, event.LoginFailure, event.SeverityWarning, fmt.Sprintf("Wrong password for user %s", user.Email), user,
+			map[string]any{"reason": "bad_password"})
+		app.errorJSON(w, errors.New("invalid creds"), http.StatusUnauthorized)
+		return
+	}
+
+	if user.Active != 1 {
+		app.publishAuthEvent(r, event.LoginFailure, event.SeverityWarning, fmt.Sprintf("Login attempt for deactivated user %s", user.Email), user,
+			map[string]any{"reason": "inactive"})
+		app.errorJSON(w, errors.New("account is deactivated"), http.StatusUnauthorized)
+		return
+	}
+
+	token, err := app.generateAccessToken(user)
 	if err != nil {
 		log.Println(err.Error())
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.errorJSON(w, errors.New("could not issue token"), http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken, err := app.generateRefreshToken(user)
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not issue token"), http.StatusInternalServerError)
 		return
 	}
 
+	app.publishAuthEvent(r, event.LoginSuccess, event.SeverityInfo, fmt.Sprintf("User %s logged in", user.Email), user, nil)
+
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Welcome back %s", user.Email),
-		Data:    user,
+		Data: authResponse{
+			User:         user,
+			AccessToken:  token,
+			RefreshToken: refreshToken,
+		},
 	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) LogItem(name, data string) error {
-	var entry struct {
-		Name string `json:"name"`
-		Data string `json:"data"`
+// Refresh exchanges a refresh token for a new access token. The presented token is
+// rotated; presenting an already rotated token revokes its whole family.
+func (app *Config) Refresh(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
 	}
 
-	entry.Name = name
-	entry.Data = data
+	err := app.readJSON(w, r, &requestPayload)
 
-	jsonData, _ := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
 
-	req, err := http.NewRequest("POST", "http://logger-service/log", bytes.NewBuffer(jsonData))
+	token, err := app.Models.Token.GetByPlainText(requestPayload.RefreshToken)
 
 	if err != nil {
-		return err
+		app.errorJSON(w, errors.New("invalid token"), http.StatusUnauthorized)
+		return
+	}
+
+	if token.RevokedAt.Valid {
+		app.revokeFamily(token)
+		app.errorJSON(w, errors.New("invalid token"), http.StatusUnauthorized)
+		return
+	}
+
+	if token.Expired() {
+		app.errorJSON(w, errors.New("token expired"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(token.UserID)
+
+	if err != nil || user.Active != 1 {
+		app.errorJSON(w, errors.New("invalid token"), http.StatusUnauthorized)
+		return
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	next, err := token.Rotate(app.JWT.RefreshExpiry)
 
-	client := &http.Client{}
-	_, err = client.Do(req)
+	if errors.Is(err, data.ErrTokenRevoked) {
+		app.revokeFamily(token)
+		app.errorJSON(w, errors.New("invalid token"), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not issue token"), http.StatusInternalServerError)
+		return
+	}
 
+	accessToken, err := app.generateAccessToken(user)
 	if err != nil {
-		return err
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not issue token"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Token refreshed",
+		Data: authResponse{
+			User:        user,
+			AccessToken: accessToken,
+			RefreshToken: issuedToken{
+				Token:     next.PlainText,
+				ExpiresAt: next.ExpiresAt,
+			},
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// Logout revokes the presented refresh token along with the rest of its family
+func (app *Config) Logout(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.Models.Token.GetByPlainText(requestPayload.RefreshToken)
+
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid token"), http.StatusUnauthorized)
+		return
+	}
+
+	err = app.Models.Token.RevokeFamily(token.FamilyID)
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Logged out",
 	}
 
-	return nil
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// a revoked refresh token was presented again, so assume it was stolen and
+// cut off every token derived from the same login
+func (app *Config) revokeFamily(token *data.Token) {
+	log.Printf("refresh token reuse detected for user %d, revoking family %s", token.UserID, token.FamilyID)
+
+	err := app.Models.Token.RevokeFamily(token.FamilyID)
+	if err != nil {
+		log.Println(err.Error())
+	}
 }
@@ -74,3 +74,14 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// validation error json
+func (app *Config) validationErrorJSON(w http.ResponseWriter, v *validator) error {
+	payload := jsonResponse{
+		Error:   true,
+		Message: "validation failed",
+		Data:    v.Errors,
+	}
+
+	return app.writeJSON(w, http.StatusUnprocessableEntity, payload)
+}
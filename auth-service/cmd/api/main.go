@@ -2,10 +2,16 @@ package main
 
 import (
 	"auth-service/data"
+	"auth-service/event"
+	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"rabbit"
+	"syscall"
 	"time"
 
 	_ "github.com/jackc/pgconn"
@@ -13,39 +19,90 @@ import (
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
-const webPort = "80"
-
 var counts int64
 
 type Config struct {
-	DB     *sql.DB
-	Models data.Models
+	DB           *sql.DB
+	Models       data.Models
+	JWT          JWTConfig
+	Emitter      *event.Emitter
+	ServiceToken string
 }
 
 func main() {
 	log.Println("-----------Starting auth service---------")
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	settings, err := loadSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// DB connection
-	conn := connectToDB()
+	conn := connectToDB(settings.DSN)
 	if conn == nil {
 		log.Println("Failed to connect to DB")
 		return
 	}
 
+	// auth events are best effort, so auth-service starts without RabbitMQ and
+	// connects in the background
+	rabbitConn := rabbit.Open(settings.RabbitURL)
+	defer rabbitConn.Close()
+
+	emitter, err := event.NewEventEmitter(rabbitConn)
+	if err != nil {
+		log.Println("could not set up auth events, they will not be published:", err)
+	} else {
+		defer emitter.Close()
+	}
+
 	app := Config{
-		DB:     conn,
-		Models: data.New(conn),
+		DB:           conn,
+		Models:       data.New(conn),
+		JWT:          settings.jwtConfig(),
+		Emitter:      emitter,
+		ServiceToken: settings.ServiceToken,
 	}
 
 	srv := &http.Server{
-		Addr:    ":" + webPort,
+		Addr:    ":" + settings.Port,
 		Handler: app.routes(),
 	}
 
-	err := srv.ListenAndServe()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var failed error
 
+	select {
+	case failed = <-serveErr:
+		log.Println("server stopped:", failed)
+	case <-ctx.Done():
+		log.Println("shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+	defer cancel()
+
+	// wait for in-flight requests before closing what they use
+	err = srv.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Fatal(err)
+		log.Println("could not drain http requests:", err)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		log.Println("could not close database:", err)
+	}
+
+	// a server that could not start, such as on a port already in use, is fatal
+	if failed != nil && !errors.Is(failed, http.ErrServerClosed) {
+		log.Fatal(failed)
 	}
 }
 
@@ -63,9 +120,7 @@ func openDB(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
-func connectToDB() *sql.DB {
-	dsn := os.Getenv("DSN")
-
+func connectToDB(dsn string) *sql.DB {
 	for {
 		db, err := openDB(dsn)
 		if err != nil {
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+// serviceTokenHeader carries the shared secret that services calling auth-service
+// on behalf of a user present
+const serviceTokenHeader = "X-Service-Token"
+
+// requireServiceToken rejects requests that don't carry the shared service
+// token, so that only the broker and other services can manage users
+func (app *Config) requireServiceToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(serviceTokenHeader)
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(app.ServiceToken)) != 1 {
+			app.errorJSON(w, errors.New("service token required"), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -22,6 +22,21 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 	mux.Post("/authenticate", app.Authenticate)
+	mux.Post("/refresh", app.Refresh)
+	mux.Post("/logout", app.Logout)
+
+	mux.Route("/users", func(mux chi.Router) {
+		mux.Use(app.requireServiceToken)
+
+		mux.Get("/", app.ListUsers)
+		mux.Post("/", app.CreateUser)
+		mux.Get("/{id}", app.GetUser)
+		mux.Put("/{id}", app.UpdateUser)
+		mux.Post("/{id}/deactivate", app.DeactivateUser)
+		mux.Post("/{id}/reset-password", app.ResetUserPassword)
+		mux.Post("/{id}/lock", app.LockUser)
+		mux.Post("/{id}/unlock", app.UnlockUser)
+	})
 
 	return mux
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"auth-service/data"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWTConfig holds the settings used to sign access tokens and to
+// expire the refresh tokens that accompany them
+type JWTConfig struct {
+	Secret        []byte
+	Expiry        time.Duration
+	RefreshExpiry time.Duration
+	Issuer        string
+}
+
+// Claims is the payload of an access token. Downstream services trust
+// UserID, Email and Admin once the signature has been verified.
+type Claims struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	Admin  bool   `json:"admin,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type issuedToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// generate a signed access token for the given user
+func (app *Config) generateAccessToken(user *data.User) (issuedToken, error) {
+	if len(app.JWT.Secret) == 0 {
+		return issuedToken{}, errors.New("jwt signing key is not configured")
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(app.JWT.Expiry)
+
+	claims := Claims{
+		UserID: user.ID,
+		Email:  user.Email,
+		Admin:  user.IsAdmin,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    app.JWT.Issuer,
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	signed, err := token.SignedString(app.JWT.Secret)
+	if err != nil {
+		return issuedToken{}, err
+	}
+
+	return issuedToken{
+		Token:     signed,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// start a new refresh token family for the given user
+func (app *Config) generateRefreshToken(user *data.User) (issuedToken, error) {
+	token, err := app.Models.Token.GenerateToken(user.ID, "", app.JWT.RefreshExpiry)
+	if err != nil {
+		return issuedToken{}, err
+	}
+
+	_, err = app.Models.Token.Insert(*token)
+	if err != nil {
+		return issuedToken{}, err
+	}
+
+	return issuedToken{
+		Token:     token.PlainText,
+		ExpiresAt: token.ExpiresAt,
+	}, nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"auth-service/data"
+	"auth-service/event"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	minPasswordLen  = 8
+)
+
+type paginationMetadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+// ListUsers returns one page of users
+func (app *Config) ListUsers(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+
+	page := readInt(r, "page", 1, v)
+	pageSize := readInt(r, "page_size", defaultPageSize, v)
+
+	v.Check(page > 0, "page", "must be greater than zero")
+	v.Check(pageSize > 0 && pageSize <= maxPageSize, "page_size", "must be between 1 and 100")
+
+	if !v.Valid() {
+		app.validationErrorJSON(w, v)
+		return
+	}
+
+	users, total, err := app.Models.User.GetPage(page, pageSize)
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not list users"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Users",
+		Data: map[string]any{
+			"users": users,
+			"metadata": paginationMetadata{
+				CurrentPage:  page,
+				PageSize:     pageSize,
+				LastPage:     int(math.Ceil(float64(total) / float64(pageSize))),
+				TotalRecords: total,
+			},
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetUser returns one user by id
+func (app *Config) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateUser validates and inserts a new user
+func (app *Config) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email     string `json:"email"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Password  string `json:"password"`
+		Active    *int   `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	active := 1
+	if requestPayload.Active != nil {
+		active = *requestPayload.Active
+	}
+
+	v := newValidator()
+	v.Check(isEmail(requestPayload.Email), "email", "must be a valid email address")
+	v.Check(notBlank(requestPayload.FirstName), "first_name", "must be provided")
+	v.Check(notBlank(requestPayload.LastName), "last_name", "must be provided")
+	v.Check(len(requestPayload.Password) >= minPasswordLen, "password", "must be at least 8 characters long")
+	v.Check(len(requestPayload.Password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(active == 0 || active == 1, "active", "must be 0 or 1")
+
+	if v.Valid() {
+		app.checkEmailAvailable(v, requestPayload.Email, 0)
+	}
+
+	if !v.Valid() {
+		app.validationErrorJSON(w, v)
+		return
+	}
+
+	id, err := app.Models.User.Insert(data.User{
+		Email:     requestPayload.Email,
+		FirstName: requestPayload.FirstName,
+		LastName:  requestPayload.LastName,
+		Password:  requestPayload.Password,
+		Active:    active,
+	})
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not create user"), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(id)
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not create user"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User created",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// UpdateUser changes the fields supplied in the request and leaves the rest untouched
+func (app *Config) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Email     *string `json:"email"`
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Active    *int    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Email != nil {
+		user.Email = *requestPayload.Email
+	}
+	if requestPayload.FirstName != nil {
+		user.FirstName = *requestPayload.FirstName
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = *requestPayload.LastName
+	}
+	if requestPayload.Active != nil {
+		user.Active = *requestPayload.Active
+	}
+
+	v := newValidator()
+	v.Check(isEmail(user.Email), "email", "must be a valid email address")
+	v.Check(notBlank(user.FirstName), "first_name", "must not be blank")
+	v.Check(notBlank(user.LastName), "last_name", "must not be blank")
+	v.Check(user.Active == 0 || user.Active == 1, "active", "must be 0 or 1")
+
+	if v.Valid() && requestPayload.Email != nil {
+		app.checkEmailAvailable(v, user.Email, user.ID)
+	}
+
+	if !v.Valid() {
+		app.validationErrorJSON(w, v)
+		return
+	}
+
+	err = user.Update()
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not update user"), http.StatusInternalServerError)
+		return
+	}
+
+	if user.Active == 0 {
+		app.revokeUserTokens(user)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User updated",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeactivateUser marks a user inactive, which blocks authentication and refresh
+// without losing the account's history
+func (app *Config) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	user.Active = 0
+
+	err := user.Update()
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not deactivate user"), http.StatusInternalServerError)
+		return
+	}
+
+	app.revokeUserTokens(user)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User deactivated",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ResetUserPassword sets a new password and signs the user out everywhere
+func (app *Config) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	v := newValidator()
+	v.Check(len(requestPayload.Password) >= minPasswordLen, "password", "must be at least 8 characters long")
+	v.Check(len(requestPayload.Password) <= 72, "password", "must not be more than 72 bytes long")
+
+	if !v.Valid() {
+		app.validationErrorJSON(w, v)
+		return
+	}
+
+	err = user.ResetPassword(requestPayload.Password)
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not reset password"), http.StatusInternalServerError)
+		return
+	}
+
+	app.revokeUserTokens(user)
+
+	app.publishAuthEvent(r, event.PasswordReset, event.SeverityInfo, fmt.Sprintf("Password reset for user %s", user.Email), user, nil)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password reset",
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// LockUser blocks authentication for a user for the requested duration. The
+// listener calls this after repeated failed logins.
+func (app *Config) LockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Duration string `json:"duration"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	duration, err := time.ParseDuration(requestPayload.Duration)
+
+	v := newValidator()
+	v.Check(err == nil && duration > 0, "duration", "must be a positive duration such as 15m")
+
+	if !v.Valid() {
+		app.validationErrorJSON(w, v)
+		return
+	}
+
+	err = user.Lock(time.Now().Add(duration))
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not lock user"), http.StatusInternalServerError)
+		return
+	}
+
+	app.revokeUserTokens(user)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User locked",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// UnlockUser lifts a lock early
+func (app *Config) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := user.Unlock()
+	if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not unlock user"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User unlocked",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// look up the user named by the {id} URL parameter, writing an error response if there isn't one
+func (app *Config) userFromURL(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, errors.New("invalid user id"), http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := app.Models.User.GetOne(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		log.Println(err.Error())
+		app.errorJSON(w, errors.New("could not load user"), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (app *Config) checkEmailAvailable(v *validator, email string, userID int) {
+	existing, err := app.Models.User.GetByEmail(email)
+	if err == nil && existing.ID != userID {
+		v.AddError("email", "is already in use")
+	}
+}
+
+func (app *Config) revokeUserTokens(user *data.User) {
+	err := app.Models.Token.RevokeAllForUser(user.ID)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// read an integer query string parameter, falling back to def when it is absent
+func readInt(r *http.Request, key string, def int, v *validator) int {
+	s := r.URL.Query().Get(key)
+	if s == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer")
+		return def
+	}
+
+	return i
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// validator collects field errors for a single request payload
+type validator struct {
+	Errors map[string]string
+}
+
+func newValidator() *validator {
+	return &validator{Errors: make(map[string]string)}
+}
+
+// Valid returns true if no errors have been recorded
+func (v *validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError records an error for a field, keeping the first one reported
+func (v *validator) AddError(field, message string) {
+	if _, exists := v.Errors[field]; !exists {
+		v.Errors[field] = message
+	}
+}
+
+// Check adds an error for a field when ok is false
+func (v *validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.AddError(field, message)
+	}
+}
+
+func isEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+func notBlank(s string) bool {
+	return strings.TrimSpace(s) != ""
+}
@@ -20,7 +20,8 @@ func New(dbPool *sql.DB) Models {
 	db = dbPool
 
 	return Models{
-		User: User{},
+		User:  User{},
+		Token: Token{},
 	}
 }
 
@@ -28,19 +29,22 @@ func New(dbPool *sql.DB) Models {
 // in this type is available to us throughout the application, anywhere that the
 // app variable is used, provided that the model is also added in the New function.
 type Models struct {
-	User User
+	User  User
+	Token Token
 }
 
 // User is the structure which holds one user from the database.
 type User struct {
-	ID        int       `json:"id"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name,omitempty"`
-	LastName  string    `json:"last_name,omitempty"`
-	Password  string    `json:"-"`
-	Active    int       `json:"active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          int        `json:"id"`
+	Email       string     `json:"email"`
+	FirstName   string     `json:"first_name,omitempty"`
+	LastName    string     `json:"last_name,omitempty"`
+	Password    string     `json:"-"`
+	Active      int        `json:"active"`
+	IsAdmin     bool       `json:"is_admin"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
 }
 
 // GetAll returns a slice of all users, sorted by last name
@@ -48,7 +52,7 @@ func (u *User) GetAll() ([]*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at
+	query := `select id, email, first_name, last_name, password, user_active, is_admin, locked_until, created_at, updated_at
 	from users order by last_name`
 
 	rows, err := db.QueryContext(ctx, query)
@@ -68,6 +72,8 @@ func (u *User) GetAll() ([]*User, error) {
 			&user.LastName,
 			&user.Password,
 			&user.Active,
+			&user.IsAdmin,
+			&user.LockedUntil,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -82,12 +88,64 @@ func (u *User) GetAll() ([]*User, error) {
 	return users, nil
 }
 
+// GetPage returns one page of users sorted by last name, along with the total
+// number of users so that callers can work out how many pages there are
+func (u *User) GetPage(page, pageSize int) ([]*User, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var total int
+	err := db.QueryRowContext(ctx, `select count(*) from users`).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `select id, email, first_name, last_name, password, user_active, is_admin, locked_until, created_at, updated_at
+	from users order by last_name, id limit $1 offset $2`
+
+	rows, err := db.QueryContext(ctx, query, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Password,
+			&user.Active,
+			&user.IsAdmin,
+			&user.LockedUntil,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, 0, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
 // GetByEmail returns one user by email
 func (u *User) GetByEmail(email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, user_active, is_admin, locked_until, created_at, updated_at from users where email = $1`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, email)
@@ -99,6 +157,8 @@ func (u *User) GetByEmail(email string) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.IsAdmin,
+		&user.LockedUntil,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -115,7 +175,7 @@ func (u *User) GetOne(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, user_active, is_admin, locked_until, created_at, updated_at from users where id = $1`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, id)
@@ -127,6 +187,8 @@ func (u *User) GetOne(id int) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.IsAdmin,
+		&user.LockedUntil,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -249,6 +311,43 @@ func (u *User) ResetPassword(password string) error {
 	return nil
 }
 
+// Lock blocks authentication for the user until the given time
+func (u *User) Lock(until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set locked_until = $1, updated_at = $2 where id = $3`
+	_, err := db.ExecContext(ctx, stmt, until, time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+
+	u.LockedUntil = &until
+
+	return nil
+}
+
+// Unlock clears any lock on the user
+func (u *User) Unlock() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set locked_until = null, updated_at = $1 where id = $2`
+	_, err := db.ExecContext(ctx, stmt, time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+
+	u.LockedUntil = nil
+
+	return nil
+}
+
+// Locked reports whether the user is currently locked out
+func (u *User) Locked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
 // PasswordMatches uses Go's bcrypt package to compare a user supplied password
 // with the hash we have stored for a given user in the database. If the password
 // and hash match, we return true; otherwise, we return false.
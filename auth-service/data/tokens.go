@@ -0,0 +1,206 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrTokenRevoked is returned when a refresh token that has already been
+// revoked (or rotated) is presented again.
+var ErrTokenRevoked = errors.New("refresh token has been revoked")
+
+// Token is the structure which holds one refresh token from the database. Only the
+// hash of the token is stored; PlainText is populated when a token is generated
+// so that it can be handed to the client exactly once.
+type Token struct {
+	ID        int          `json:"-"`
+	UserID    int          `json:"-"`
+	PlainText string       `json:"token"`
+	Hash      string       `json:"-"`
+	FamilyID  string       `json:"-"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	RevokedAt sql.NullTime `json:"-"`
+	CreatedAt time.Time    `json:"-"`
+}
+
+// GenerateToken creates a new, unsaved refresh token for a user. Tokens issued at
+// login start a new family; pass the family of the token being rotated to keep
+// the chain together so that reuse can revoke every descendant.
+func (t *Token) GenerateToken(userID int, familyID string, ttl time.Duration) (*Token, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, err
+	}
+
+	if familyID == "" {
+		familyBytes := make([]byte, 16)
+		if _, err := rand.Read(familyBytes); err != nil {
+			return nil, err
+		}
+		familyID = hex.EncodeToString(familyBytes)
+	}
+
+	plainText := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	return &Token{
+		UserID:    userID,
+		PlainText: plainText,
+		Hash:      hashToken(plainText),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+// Insert inserts a new refresh token into the database, and returns the ID of the newly inserted row
+func (t *Token) Insert(token Token) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into refresh_tokens (user_id, token_hash, family_id, expires_at, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := db.QueryRowContext(ctx, stmt,
+		token.UserID,
+		token.Hash,
+		token.FamilyID,
+		token.ExpiresAt,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetByPlainText returns one refresh token by the value the client holds
+func (t *Token) GetByPlainText(plainText string) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, token_hash, family_id, expires_at, revoked_at, created_at
+	from refresh_tokens where token_hash = $1`
+
+	var token Token
+	row := db.QueryRowContext(ctx, query, hashToken(plainText))
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Hash,
+		&token.FamilyID,
+		&token.ExpiresAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Rotate revokes the receiver and issues its replacement in the same family. If the
+// receiver was revoked concurrently, ErrTokenRevoked is returned and nothing is issued.
+func (t *Token) Rotate(ttl time.Duration) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	next, err := t.GenerateToken(t.UserID, t.FamilyID, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into refresh_tokens (user_id, token_hash, family_id, expires_at, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		next.UserID,
+		next.Hash,
+		next.FamilyID,
+		next.ExpiresAt,
+		time.Now(),
+	).Scan(&next.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = `update refresh_tokens set revoked_at = $1, replaced_by = $2
+		where id = $3 and revoked_at is null`
+
+	res, err := tx.ExecContext(ctx, stmt, time.Now(), next.ID, t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == 0 {
+		return nil, ErrTokenRevoked
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+// RevokeFamily revokes every token descended from the same login
+func (t *Token) RevokeFamily(familyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every outstanding refresh token belonging to a user
+func (t *Token) RevokeAllForUser(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Expired reports whether the token is past its expiry time
+func (t *Token) Expired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+func hashToken(plainText string) string {
+	hash := sha256.Sum256([]byte(plainText))
+	return hex.EncodeToString(hash[:])
+}
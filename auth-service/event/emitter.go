@@ -0,0 +1,158 @@
+package event
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"rabbit"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// publishTimeout bounds how long Push waits for RabbitMQ to confirm an event
+const publishTimeout = 5 * time.Second
+
+// ErrNacked means RabbitMQ refused responsibility for an event
+var ErrNacked = errors.New("event was not accepted by rabbitmq")
+
+// Emitter publishes events on the logs_topic exchange over one long-lived
+// channel in confirm mode, so Push only returns once RabbitMQ has taken the
+// event. The channel is reopened after the connection drops.
+type Emitter struct {
+	connection *rabbit.Connection
+
+	mu       sync.Mutex
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+// setupExchange declares the exchange events are published on
+func setupExchange(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
+
+	if err != nil {
+		return err
+	}
+
+	defer channel.Close()
+
+	return declareExchange(channel)
+}
+
+// open opens the confirm-mode channel if there isn't a usable one. It is called
+// with mu held.
+func (e *Emitter) open() error {
+	if e.channel != nil && !e.channel.IsClosed() {
+		return nil
+	}
+
+	channel, err := e.connection.Channel()
+	if err != nil {
+		return err
+	}
+
+	err = channel.Confirm(false)
+	if err != nil {
+		channel.Close()
+		return err
+	}
+
+	e.channel = channel
+	e.confirms = channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	return nil
+}
+
+// reset drops a channel left in an unknown state, so the next Push opens a new
+// one. It is called with mu held.
+func (e *Emitter) reset() {
+	if e.channel != nil {
+		e.channel.Close()
+		e.channel = nil
+	}
+}
+
+// Push publishes an event on the logs_topic exchange and waits for RabbitMQ to
+// confirm it. Build routingKey with RoutingKey so that listeners can bind by
+// family and severity. Each event gets a message id so that consumers can tell
+// a redelivery from a new event.
+func (e *Emitter) Push(event string, routingKey string) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	err := e.open()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	err = e.channel.PublishWithContext(
+		ctx,
+		"logs_topic",
+		routingKey,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  "text/plain",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    hex.EncodeToString(id),
+			Timestamp:    time.Now(),
+			Body:         []byte(event),
+		},
+	)
+	if err != nil {
+		e.reset()
+		return err
+	}
+
+	select {
+	case confirm := <-e.confirms:
+		if confirm.DeliveryTag == 0 {
+			// the notify channel was closed along with the AMQP channel
+			e.reset()
+			return errors.New("channel closed before the event was confirmed")
+		}
+
+		if !confirm.Ack {
+			return ErrNacked
+		}
+
+		return nil
+	case <-ctx.Done():
+		// the confirm may still arrive and would be taken for the next event's
+		e.reset()
+		return fmt.Errorf("waiting for publish confirm: %w", ctx.Err())
+	}
+}
+
+// Close closes the channel. The connection is left open.
+func (e *Emitter) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.reset()
+}
+
+// NewEventEmitter declares the exchange, again on every reconnect, and returns
+// an Emitter whose channel is opened on first use. The exchange is not declared
+// yet if the connection is still being made.
+func NewEventEmitter(conn *rabbit.Connection) (*Emitter, error) {
+	err := conn.OnConnect(setupExchange)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Emitter{connection: conn}, nil
+}
@@ -0,0 +1,70 @@
+package event
+
+import (
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Topic families published on the logs_topic exchange. Routing keys take the
+// form <family>.<SEVERITY>, e.g. log.ERROR or auth.WARNING.
+const (
+	FamilyLog  = "log"
+	FamilyAuth = "auth"
+	FamilyMail = "mail"
+)
+
+// Severities used as the second segment of a routing key
+const (
+	SeverityDebug   = "DEBUG"
+	SeverityInfo    = "INFO"
+	SeverityWarning = "WARNING"
+	SeverityError   = "ERROR"
+)
+
+func declareExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		"logs_topic", // name
+		"topic",      // type
+		true,         // durable?
+		false,        // auto-deleted?
+		false,        // internal?
+		false,        // no-wait?
+		nil,          // arguments
+	)
+}
+
+// RoutingKey builds the routing key for an event of the given family and severity.
+// Unknown or empty severities are published as INFO.
+func RoutingKey(family, severity string) string {
+	switch strings.ToUpper(severity) {
+	case SeverityDebug:
+		severity = SeverityDebug
+	case SeverityWarning, "WARN":
+		severity = SeverityWarning
+	case SeverityError:
+		severity = SeverityError
+	default:
+		severity = SeverityInfo
+	}
+
+	return family + "." + severity
+}
+
+// Auth event names, carried in the payload name of auth.* events
+const (
+	LoginSuccess  = "login.success"
+	LoginFailure  = "login.failure"
+	PasswordReset = "password.reset"
+)
+
+// Payload is the event body, in the same shape the listener and logger-service expect
+type Payload struct {
+	Name     string         `json:"name"`
+	Data     string         `json:"data"`
+	Severity string         `json:"severity,omitempty"`
+	Service  string         `json:"service,omitempty"`
+	TraceID  string         `json:"trace_id,omitempty"`
+	UserID   int64          `json:"user_id,omitempty"`
+	Fields   map[string]any `json:"fields,omitempty"`
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// errCircuitOpen is returned without calling a downstream whose breaker is open
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// breaker stops calls to a downstream after threshold consecutive failures. Once
+// cooldown has passed it lets a single probe through: success closes it again,
+// failure reopens it for another cooldown.
+type breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+// breakerStatus is a breaker as shown on /status
+type breakerStatus struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+func newBreaker(name string, threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     breakerClosed,
+	}
+}
+
+// allow reports whether a call may go ahead
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return errCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker with the outcome of an allowed call
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, which says nothing either way
+		return
+	}
+
+	if !countsAsFailure(err) {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *breaker) status() breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := breakerStatus{
+		Name:      b.name,
+		State:     b.state,
+		Failures:  b.failures,
+		LastError: b.lastError,
+	}
+
+	if b.state != breakerClosed {
+		openedAt := b.openedAt
+		s.OpenedAt = &openedAt
+	}
+
+	return s
+}
+
+// countsAsFailure separates a downstream that is down from one that answered.
+// Rejected requests and callers going away say nothing about its health.
+func countsAsFailure(err error) bool {
+	var rejected *rejectedError
+
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &rejected):
+		return false
+	case errors.Is(err, context.Canceled):
+		return false
+	default:
+		return true
+	}
+}
+
+// notSent reports whether err means the request never reached the downstream,
+// which makes it safe to retry even when the call isn't idempotent
+func notSent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	return status.Code(err) == codes.Unavailable
+}
+
+// retryPolicy bounds how often and how quickly a failed call is repeated
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+}
+
+// downstream guards calls to one service with a breaker and a retry policy
+type downstream struct {
+	breaker *breaker
+	retry   retryPolicy
+}
+
+// downstreamError is a call that failed because the service was unreachable,
+// unhealthy or its breaker was open
+type downstreamError struct {
+	service string
+	err     error
+}
+
+func (e *downstreamError) Error() string {
+	return fmt.Sprintf("%s: %v", e.service, e.err)
+}
+
+func (e *downstreamError) Unwrap() error {
+	return e.err
+}
+
+// call runs fn through the breaker. Failures are retried with jittered
+// exponential backoff when the call is idempotent or never reached the service.
+func (d *downstream) call(ctx context.Context, idempotent bool, fn func() error) error {
+	delay := d.retry.baseDelay
+
+	for attempt := 1; ; attempt++ {
+		err := d.breaker.allow()
+		if err != nil {
+			return &downstreamError{d.breaker.name, err}
+		}
+
+		err = fn()
+		d.breaker.record(err)
+
+		if !countsAsFailure(err) {
+			return err
+		}
+
+		if attempt > d.retry.attempts || !(idempotent || notSent(err)) {
+			return &downstreamError{d.breaker.name, err}
+		}
+
+		// full jitter spreads out retries from concurrent requests
+		wait := jitter(delay)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return &downstreamError{d.breaker.name, err}
+		}
+
+		delay *= 2
+	}
+}
+
+// downstreamFailed answers a request whose downstream call failed. Services that
+// are down or unhealthy get a 503, or a 504 if they timed out, without leaking
+// connection details to the caller.
+func (app *Config) downstreamFailed(w http.ResponseWriter, err error) {
+	var down *downstreamError
+	if !errors.As(err, &down) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Println(err)
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		app.errorJSON(w, fmt.Errorf("%s timed out", down.service), http.StatusGatewayTimeout)
+		return
+	}
+
+	app.errorJSON(w, fmt.Errorf("%s is unavailable", down.service), http.StatusServiceUnavailable)
+}
+
+// Status reports the state of every downstream breaker. Last errors name
+// internal addresses, so only admins see them.
+func (app *Config) Status(w http.ResponseWriter, r *http.Request) {
+	identity, _ := identityFromContext(r.Context())
+
+	breakers := make([]breakerStatus, 0, len(app.Downstreams))
+	for _, d := range app.Downstreams {
+		s := d.breaker.status()
+		if !identity.Admin {
+			s.LastError = ""
+		}
+
+		breakers = append(breakers, s)
+	}
+
+	var payload jsonResponse
+	payload.Error = false
+	payload.Message = "Downstream status"
+	payload.Data = breakers
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns a random duration between zero and max
+func jitter(max time.Duration) time.Duration {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	return time.Duration(jitterRand.Int63n(int64(max) + 1))
+}
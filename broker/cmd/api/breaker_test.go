@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	errDown := errors.New("connection refused")
+
+	// each step is a call through the breaker: whether it is let through, how
+	// it ends if it is, and the state the breaker is left in
+	type step struct {
+		cooledDown bool  // the cooldown has passed since the breaker opened
+		allowed    bool  // allow lets the call through
+		result     error // outcome recorded for an allowed call
+		state      string
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "stays closed below the threshold",
+			steps: []step{
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: nil, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+			},
+		},
+		{
+			name: "opens at the threshold and fails fast",
+			steps: []step{
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerOpen},
+				{allowed: false, state: breakerOpen},
+			},
+		},
+		{
+			name: "half-open probe that succeeds closes it",
+			steps: []step{
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerOpen},
+				{cooledDown: true, allowed: true, result: nil, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+			},
+		},
+		{
+			name: "half-open probe that fails reopens it",
+			steps: []step{
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerOpen},
+				{cooledDown: true, allowed: true, result: errDown, state: breakerOpen},
+				{allowed: false, state: breakerOpen},
+			},
+		},
+		{
+			name: "rejections and cancelled calls don't count",
+			steps: []step{
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: &rejectedError{errors.New("invalid severity")}, state: breakerClosed},
+				{allowed: true, result: context.Canceled, state: breakerClosed},
+				{allowed: true, result: nil, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+				{allowed: true, result: errDown, state: breakerClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("test", 3, time.Minute)
+
+			for i, s := range tt.steps {
+				if s.cooledDown {
+					b.openedAt = time.Now().Add(-b.cooldown)
+				}
+
+				err := b.allow()
+				if allowed := err == nil; allowed != s.allowed {
+					t.Fatalf("step %d: allowed = %v, want %v", i, allowed, s.allowed)
+				}
+
+				if err == nil {
+					b.record(s.result)
+				} else if !errors.Is(err, errCircuitOpen) {
+					t.Fatalf("step %d: allow() = %v, want %v", i, err, errCircuitOpen)
+				}
+
+				if state := b.status().State; state != s.state {
+					t.Fatalf("step %d: state = %s, want %s", i, state, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerLetsOneProbeThrough(t *testing.T) {
+	b := newBreaker("test", 1, time.Minute)
+
+	b.record(errors.New("connection refused"))
+	b.openedAt = time.Now().Add(-b.cooldown)
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe: allow() = %v, want nil", err)
+	}
+
+	if state := b.status().State; state != breakerHalfOpen {
+		t.Fatalf("state = %s, want %s", state, breakerHalfOpen)
+	}
+
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("second call while probing: allow() = %v, want %v", err, errCircuitOpen)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"broker/logs"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mailmsg"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// serviceResponse is a reply from one of the services behind the broker
+type serviceResponse struct {
+	StatusCode int
+	Body       jsonResponse
+}
+
+// serviceClient sends JSON requests to one HTTP service, reusing connections
+// across requests
+type serviceClient struct {
+	baseURL    string
+	http       *http.Client
+	downstream *downstream
+}
+
+func newServiceClient(baseURL string, timeout time.Duration, d *downstream) serviceClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 100
+
+	return serviceClient{
+		baseURL: baseURL,
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		downstream: d,
+	}
+}
+
+// statusError is a 503 reply. It trips the breaker but is still relayed to the
+// caller as the service's own answer. Other error statuses are answers about
+// the request, such as a relay refusing a message, and leave the breaker alone.
+type statusError struct {
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "returned " + e.status
+}
+
+// do sends body as JSON and decodes the JSON reply. Replies with an error status
+// are returned as they are, so callers can relay the service's own status and
+// message. GET requests are retried; others only when they never got through.
+func (c serviceClient) do(ctx context.Context, method, path string, body any, header http.Header) (serviceResponse, error) {
+	var payload []byte
+
+	if body != nil {
+		var err error
+
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return serviceResponse{}, err
+		}
+	}
+
+	var result serviceResponse
+
+	err := c.downstream.call(ctx, method == http.MethodGet, func() error {
+		var err error
+
+		result, err = c.send(ctx, method, path, payload, header)
+		if err == nil && result.StatusCode == http.StatusServiceUnavailable {
+			return &statusError{http.StatusText(result.StatusCode)}
+		}
+
+		return err
+	})
+
+	var replied *statusError
+	if errors.As(err, &replied) {
+		return result, nil
+	}
+
+	return result, err
+}
+
+func (c serviceClient) send(ctx context.Context, method, path string, payload []byte, header http.Header) (serviceResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return serviceResponse{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return serviceResponse{}, err
+	}
+
+	defer resp.Body.Close()
+
+	result := serviceResponse{StatusCode: resp.StatusCode}
+
+	err = json.NewDecoder(resp.Body).Decode(&result.Body)
+	if err != nil {
+		return result, fmt.Errorf("returned %s with an unreadable body", resp.Status)
+	}
+
+	return result, nil
+}
+
+// AuthClient talks to auth-service. User management requests carry the shared
+// service token auth-service requires for them.
+type AuthClient struct {
+	serviceClient
+	serviceToken string
+}
+
+func NewAuthClient(baseURL, serviceToken string, timeout time.Duration, d *downstream) *AuthClient {
+	return &AuthClient{newServiceClient(baseURL, timeout, d), serviceToken}
+}
+
+// Authenticate checks credentials on behalf of the caller of r, passing on their
+// address and user agent for the sign-in audit
+func (c *AuthClient) Authenticate(r *http.Request, a AuthPayload) (serviceResponse, error) {
+	header := http.Header{}
+	header.Set("X-Forwarded-For", clientIP(r))
+	header.Set("User-Agent", r.UserAgent())
+
+	return c.do(r.Context(), http.MethodPost, "/authenticate", a, header)
+}
+
+// clientIP returns the caller's address without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// User sends a user management request
+func (c *AuthClient) User(ctx context.Context, method, path string, body any) (serviceResponse, error) {
+	header := http.Header{}
+	header.Set("X-Service-Token", c.serviceToken)
+
+	return c.do(ctx, method, path, body, header)
+}
+
+// MailerClient talks to mail-service
+type MailerClient struct {
+	serviceClient
+}
+
+func NewMailerClient(baseURL string, timeout time.Duration, d *downstream) *MailerClient {
+	return &MailerClient{newServiceClient(baseURL, timeout, d)}
+}
+
+// Send asks mail-service to send msg and returns its reply, which carries the
+// message id and whether it was sent or will be retried
+func (c *MailerClient) Send(ctx context.Context, msg MailPayload) (jsonResponse, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/send", msg, nil)
+	if err != nil {
+		return jsonResponse{}, err
+	}
+
+	switch {
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return resp.Body, &rejectedError{errors.New(resp.Body.Message)}
+	case resp.StatusCode == http.StatusBadGateway:
+		return resp.Body, &relayRejectedError{errors.New(resp.Body.Message)}
+	case resp.StatusCode == http.StatusServiceUnavailable:
+		return resp.Body, &downstreamError{c.downstream.breaker.name, errors.New(resp.Body.Message)}
+	case resp.StatusCode != http.StatusAccepted:
+		return resp.Body, fmt.Errorf("mail service: %s", resp.Body.Message)
+	}
+
+	return resp.Body, nil
+}
+
+// relayRejectedError is a message the SMTP relay behind mail-service refused.
+// It says nothing about mail-service's health, and is passed on as a 502.
+type relayRejectedError struct {
+	err error
+}
+
+func (e *relayRejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *relayRejectedError) Unwrap() error {
+	return e.err
+}
+
+// Record tells mail-service that msg has been queued as message id, so its
+// status can be looked up before a worker picks it up. Attachment content is
+// left out, since only the names are recorded.
+func (c *MailerClient) Record(ctx context.Context, id string, msg MailPayload) error {
+	attachments := make([]mailmsg.Attachment, len(msg.Attachments))
+	for i, a := range msg.Attachments {
+		a.Content = nil
+		attachments[i] = a
+	}
+	msg.Attachments = attachments
+
+	resp, err := c.do(ctx, http.MethodPut, "/messages/"+url.PathEscape(id), msg, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("mail service: %s", resp.Body.Message)
+	}
+
+	return nil
+}
+
+// Message fetches the delivery status of a message
+func (c *MailerClient) Message(ctx context.Context, id string) (serviceResponse, error) {
+	return c.do(ctx, http.MethodGet, "/messages/"+url.PathEscape(id), nil, nil)
+}
+
+// rpcInvalidEntry starts the error the logger's RPC server returns for entries
+// that fail validation
+const rpcInvalidEntry = "invalid entry: "
+
+// LoggerClient writes to logger-service over HTTP, net/rpc or gRPC. The RPC and
+// gRPC connections are opened once and shared by every request.
+type LoggerClient struct {
+	serviceClient
+
+	rpcAddr       string
+	timeout       time.Duration
+	rpcDownstream *downstream
+	rpcMu         sync.Mutex
+	rpc           *rpc.Client
+
+	grpcConn       *grpc.ClientConn
+	grpc           logs.LogServiceClient
+	grpcDownstream *downstream
+}
+
+// NewLoggerClient sets up the gRPC connection, which connects in the background
+// and reconnects by itself. The RPC connection is dialled on first use.
+func NewLoggerClient(baseURL, rpcAddr, grpcAddr string, timeout time.Duration, httpDown, rpcDown, grpcDown *downstream) (*LoggerClient, error) {
+	conn, err := grpc.Dial(grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoggerClient{
+		serviceClient:  newServiceClient(baseURL, timeout, httpDown),
+		rpcAddr:        rpcAddr,
+		timeout:        timeout,
+		rpcDownstream:  rpcDown,
+		grpcConn:       conn,
+		grpc:           logs.NewLogServiceClient(conn),
+		grpcDownstream: grpcDown,
+	}, nil
+}
+
+// Log writes entry over HTTP
+func (c *LoggerClient) Log(ctx context.Context, entry LogPayload) error {
+	resp, err := c.do(ctx, http.MethodPost, "/log", entry, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &rejectedError{errors.New(resp.Body.Message)}
+	} else if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("logger service: %s", resp.Body.Message)
+	}
+
+	return nil
+}
+
+// LogRPC writes entry over net/rpc and returns the server's reply. Entries the
+// logger refuses as invalid come back as rejections.
+func (c *LoggerClient) LogRPC(ctx context.Context, entry LogPayload) (string, error) {
+	rpcPayload := RPCPayload{
+		Name:     entry.Name,
+		Data:     entry.Data,
+		Severity: entry.Severity,
+		Service:  entry.Service,
+		TraceID:  entry.TraceID,
+		UserID:   entry.UserID,
+		Fields:   entry.Fields,
+	}
+
+	var result string
+
+	err := c.rpcDownstream.call(ctx, false, func() error {
+		client, err := c.rpcClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+
+		call := client.Go("RPCServer.LogInfo", rpcPayload, &result, make(chan *rpc.Call, 1))
+
+		select {
+		case <-call.Done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if serverErr, ok := call.Error.(rpc.ServerError); ok {
+			// only invalid entries are rejections; the logger failing to store
+			// an entry is a transport failure like any other
+			if strings.HasPrefix(string(serverErr), rpcInvalidEntry) {
+				return &rejectedError{errors.New(strings.TrimPrefix(string(serverErr), rpcInvalidEntry))}
+			}
+			return serverErr
+		} else if call.Error != nil {
+			// the connection is broken; the next call dials a fresh one
+			c.resetRPC(client)
+		}
+
+		return call.Error
+	})
+
+	return result, err
+}
+
+// LogGRPC writes entry over gRPC. Entries the logger refuses as invalid come
+// back as rejections.
+func (c *LoggerClient) LogGRPC(ctx context.Context, entry LogPayload) error {
+	var fields *structpb.Struct
+
+	if len(entry.Fields) > 0 {
+		var err error
+
+		fields, err = structpb.NewStruct(entry.Fields)
+		if err != nil {
+			return &rejectedError{err}
+		}
+	}
+
+	return c.grpcDownstream.call(ctx, false, func() error {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+
+		_, err := c.grpc.WriteLog(ctx, &logs.LogRequest{
+			LogEntry: &logs.Log{
+				Name:     entry.Name,
+				Data:     entry.Data,
+				Severity: entry.Severity,
+				Service:  entry.Service,
+				TraceId:  entry.TraceID,
+				UserId:   entry.UserID,
+				Fields:   fields,
+			},
+		})
+
+		if status.Code(err) == codes.InvalidArgument {
+			return &rejectedError{err}
+		}
+
+		return err
+	})
+}
+
+// rpcClient returns the shared RPC client, dialling it if there isn't one. The
+// dial is bounded by ctx and the client timeout, and runs without holding
+// rpcMu, so a logger that stops answering doesn't hold up other callers.
+func (c *LoggerClient) rpcClient(ctx context.Context) (*rpc.Client, error) {
+	c.rpcMu.Lock()
+	client := c.rpc
+	c.rpcMu.Unlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	dialer := net.Dialer{Timeout: c.timeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", c.rpcAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	client = rpc.NewClient(conn)
+
+	c.rpcMu.Lock()
+	defer c.rpcMu.Unlock()
+
+	if c.rpc != nil {
+		// another call dialled first; keep its client
+		client.Close()
+		return c.rpc, nil
+	}
+
+	c.rpc = client
+
+	return client, nil
+}
+
+func (c *LoggerClient) resetRPC(broken *rpc.Client) {
+	c.rpcMu.Lock()
+	defer c.rpcMu.Unlock()
+
+	if c.rpc == broken {
+		c.rpc.Close()
+		c.rpc = nil
+	}
+}
+
+// Close closes the RPC and gRPC connections
+func (c *LoggerClient) Close() {
+	c.rpcMu.Lock()
+	if c.rpc != nil {
+		c.rpc.Close()
+		c.rpc = nil
+	}
+	c.rpcMu.Unlock()
+
+	c.grpcConn.Close()
+}
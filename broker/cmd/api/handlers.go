@@ -2,17 +2,14 @@ package main
 
 import (
 	"broker/event"
-	"broker/logs"
-	"bytes"
-	"context"
+	"encoding/gob"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"mailmsg"
 	"net/http"
-	"net/rpc"
-	"time"
-
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"strings"
 )
 
 type RequestPayload struct {
@@ -20,6 +17,13 @@ type RequestPayload struct {
 	Auth   AuthPayload `json:"auth,omitempty"`
 	Log    LogPayload  `json:"log,omitempty"`
 	Mail   MailPayload `json:"mail,omitempty"`
+	User   UserPayload `json:"user,omitempty"`
+	// Transport picks how a log action reaches the logger: http, rpc, grpc or
+	// rabbitmq. The broker's LOG_TRANSPORTS chain is tried after it.
+	Transport string `json:"transport,omitempty"`
+	// Mode picks how a mail action is sent: sync, the default, waits for SMTP;
+	// async queues it and returns a job id.
+	Mode string `json:"mode,omitempty"`
 }
 
 type AuthPayload struct {
@@ -28,15 +32,24 @@ type AuthPayload struct {
 }
 
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string         `json:"name"`
+	Data     string         `json:"data"`
+	Severity string         `json:"severity,omitempty"`
+	Service  string         `json:"service,omitempty"`
+	TraceID  string         `json:"trace_id,omitempty"`
+	UserID   int64          `json:"user_id,omitempty"`
+	Fields   map[string]any `json:"fields,omitempty"`
 }
 
 type MailPayload struct {
-	From    string `json:"from"`
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	Message string `json:"message"`
+	mailmsg.Addressing
+	Subject  string         `json:"subject"`
+	Message  string         `json:"message"`
+	Template string         `json:"template,omitempty"`
+	Version  int            `json:"version,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+
+	Attachments []mailmsg.Attachment `json:"attachments,omitempty"`
 }
 
 func (app *Config) Broker(w http.ResponseWriter, r *http.Request) {
@@ -50,49 +63,73 @@ func (app *Config) Broker(w http.ResponseWriter, r *http.Request) {
 func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 	var requestPayload RequestPayload
 
-	err := app.readJSON(w, r, &requestPayload)
+	// only signed-in callers may send a body big enough for attachments, and only
+	// with a mail action
+	limit := int64(maxBodyBytes)
+	if _, ok := identityFromContext(r.Context()); ok {
+		limit = app.Attachments.RequestBytes()
+	}
+
+	body := &countingBody{ReadCloser: r.Body}
+	r.Body = body
+
+	err := app.readJSONLimit(w, r, &requestPayload, limit)
 
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
+	if requestPayload.Action != "mail" && body.n > maxBodyBytes {
+		app.errorJSON(w, errors.New("request body too large"), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if requestPayload.Action != "auth" {
+		if _, ok := identityFromContext(r.Context()); !ok {
+			app.errorJSON(w, errors.New("authentication required"), http.StatusUnauthorized)
+			return
+		}
+	}
+
 	switch requestPayload.Action {
 	case "auth":
-		app.Authenticate(w, requestPayload.Auth)
+		app.Authenticate(w, r, requestPayload.Auth)
 		return
 	case "mail":
-		app.SendMail(w, requestPayload.Mail)
+		switch requestPayload.Mode {
+		case "", mailSync:
+			app.SendMail(w, r, requestPayload.Mail)
+		case mailAsync:
+			app.QueueMail(w, r, requestPayload.Mail)
+		default:
+			app.errorJSON(w, errors.New("mode must be sync or async"), http.StatusBadRequest)
+		}
 		return
 	case "log":
-		app.logItemViaRPC(w, requestPayload.Log)
+		err = attributeToCaller(r, &requestPayload.Log)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusForbidden)
+			return
+		}
+
+		app.logItem(w, r, requestPayload.Transport, requestPayload.Log)
+	case "list-users", "get-user", "create-user", "update-user", "deactivate-user", "reset-password", "unlock-user":
+		app.ManageUser(w, r, requestPayload.Action, requestPayload.User)
 	default:
 		app.errorJSON(w, errors.New("invalid action"), http.StatusBadRequest)
 		return
 	}
 }
 
-func (app *Config) Authenticate(w http.ResponseWriter, a AuthPayload) {
-	jsonData, _ := json.MarshalIndent(a, "", "  ")
-
-	req, err := http.NewRequest("POST", "http://auth-service/authenticate", bytes.NewBuffer(jsonData))
+func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request, a AuthPayload) {
+	resp, err := app.Auth.Authenticate(r, a)
 
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.downstreamFailed(w, err)
 		return
 	}
 
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
-
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	defer resp.Body.Close()
-
 	if resp.StatusCode == http.StatusUnauthorized {
 		app.errorJSON(w, errors.New("invalid creds"), http.StatusUnauthorized)
 		return
@@ -101,140 +138,93 @@ func (app *Config) Authenticate(w http.ResponseWriter, a AuthPayload) {
 		return
 	}
 
-	var jsonFromService jsonResponse
-
-	err = json.NewDecoder(resp.Body).Decode(&jsonFromService)
-
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	if jsonFromService.Error {
-		app.errorJSON(w, errors.New(jsonFromService.Message), http.StatusInternalServerError)
+	if resp.Body.Error {
+		app.errorJSON(w, errors.New(resp.Body.Message), http.StatusInternalServerError)
 		return
 	}
 
 	var payload jsonResponse
 	payload.Error = false
 	payload.Message = "Welcome back"
-	payload.Data = jsonFromService.Data
+	payload.Data = resp.Body.Data
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) LogItem(w http.ResponseWriter, entry LogPayload) {
-	jsonData, err := json.MarshalIndent(entry, "", "  ")
-
+func (app *Config) SendMail(w http.ResponseWriter, r *http.Request, msg MailPayload) {
+	err := msg.validate(app.Attachments)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.invalidMail(w, err)
 		return
 	}
 
-	req, err := http.NewRequest("POST", "http://logger-service/log", bytes.NewBuffer(jsonData))
-
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
+	reply, err := app.Mailer.Send(r.Context(), msg)
 
-	resp, err := client.Do(req)
+	identity, _ := identityFromContext(r.Context())
+	app.publishMailEvent(msg, int64(identity.UserID), err)
 
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+	var rejected *rejectedError
+	var relayRejected *relayRejectedError
+	if errors.As(err, &rejected) {
+		// relay the delivery status of a message mail-service refused
+		app.writeJSON(w, http.StatusBadRequest, jsonResponse{
+			Error:   true,
+			Message: err.Error(),
+			Data:    reply.Data,
+		})
 		return
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusCreated {
-		app.errorJSON(w, errors.New("error logger service"), http.StatusInternalServerError)
+	} else if errors.As(err, &relayRejected) {
+		// and of one the SMTP relay refused
+		app.writeJSON(w, http.StatusBadGateway, jsonResponse{
+			Error:   true,
+			Message: err.Error(),
+			Data:    reply.Data,
+		})
+		return
+	} else if err != nil {
+		app.downstreamFailed(w, err)
 		return
 	}
 
 	var jsonFromService jsonResponse
 	jsonFromService.Error = false
-	jsonFromService.Message = "Logging successful"
+	jsonFromService.Message = reply.Message
+	jsonFromService.Data = reply.Data
 
 	app.writeJSON(w, http.StatusAccepted, jsonFromService)
 }
 
-func (app *Config) SendMail(w http.ResponseWriter, msg MailPayload) {
-	jsonData, err := json.MarshalIndent(msg, "", "  ")
-
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	req, err := http.NewRequest("POST", "http://mailer-service/send", bytes.NewBuffer(jsonData))
-
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
-
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
+// publishMailEvent records the outcome of a mail action on the mail.* topics.
+// The event is attributed to userID, the caller who sent the mail. Publishing
+// is best effort and never fails the mail action itself.
+func (app *Config) publishMailEvent(msg MailPayload, userID int64, sendErr error) {
+	payload := LogPayload{
+		Name:     "mail",
+		Data:     fmt.Sprintf("Mail to %s: %s", strings.Join(msg.Recipients(), ", "), msg.Subject),
+		Severity: event.SeverityInfo,
+		Service:  callerService,
+		UserID:   userID,
 	}
 
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusAccepted {
-		app.errorJSON(w, errors.New("error mail service"), http.StatusInternalServerError)
-		return
+	if sendErr != nil {
+		payload.Severity = event.SeverityError
+		payload.Data += " failed: " + sendErr.Error()
 	}
 
-	var jsonFromService jsonResponse
-	jsonFromService.Error = false
-	jsonFromService.Message = "Mail sent to " + msg.To
-
-	app.writeJSON(w, http.StatusAccepted, jsonFromService)
-}
-
-func (app *Config) logEventViaRabbit(w http.ResponseWriter, l LogPayload) {
-	err := app.pushToQueue(l.Name, l.Data)
-
+	err := app.pushToQueue(event.FamilyMail, payload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
+		log.Println("could not publish mail event:", err)
 	}
-
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Logged via RabbitMQ"
-
-	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) pushToQueue(name, msg string) error {
-	emitter, err := event.NewEventEmitter(app.Rabbit)
-	if err != nil {
-		return err
-	}
-
-	payload := LogPayload{
-		Name: name,
-		Data: msg,
-	}
-
+// pushToQueue publishes payload under the given topic family, routed by its severity
+func (app *Config) pushToQueue(family string, payload LogPayload) error {
 	jsonData, err := json.MarshalIndent(payload, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	err = emitter.Push(string(jsonData), "log.INFO")
+	err = app.Emitter.Push(string(jsonData), event.RoutingKey(family, payload.Severity))
 
 	if err != nil {
 		return err
@@ -244,36 +234,19 @@ func (app *Config) pushToQueue(name, msg string) error {
 }
 
 type RPCPayload struct {
-	Name string
-	Data string
+	Name     string
+	Data     string
+	Severity string
+	Service  string
+	TraceID  string
+	UserID   int64
+	Fields   map[string]any
 }
 
-func (app *Config) logItemViaRPC(w http.ResponseWriter, l LogPayload) {
-	client, err := rpc.Dial("tcp", "logger-service:5001")
-
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	rpcPayload := RPCPayload{
-		Name: l.Name,
-		Data: l.Data,
-	}
-
-	var result string
-
-	err = client.Call("RPCServer.LogInfo", rpcPayload, &result)
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = result
-
-	app.writeJSON(w, http.StatusAccepted, payload)
+func init() {
+	// nested field values travel as interface values, so gob needs to know their concrete types
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
 }
 
 func (app *Config) logItemViaGRPC(w http.ResponseWriter, r *http.Request) {
@@ -286,35 +259,39 @@ func (app *Config) logItemViaGRPC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn, err := grpc.Dial("logger-service:50001", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
-
+	err = attributeToCaller(r, &requestPayload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.errorJSON(w, err, http.StatusForbidden)
 		return
 	}
 
-	defer conn.Close()
+	app.logItem(w, r, transportGRPC, requestPayload)
+}
 
-	client := logs.NewLogServiceClient(conn)
+// callerService is the service recorded on entries written through the broker.
+// Whatever service the caller names is ignored, since listeners trust entries
+// from other services, such as auth-service's sign-in audit, to be genuine.
+const callerService = "broker"
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+// attributeToCaller sets the entry's service to the broker and its user to the
+// signed-in caller. Admins may log on behalf of another user; anyone else
+// naming a different user is refused.
+func attributeToCaller(r *http.Request, entry *LogPayload) error {
+	entry.Service = callerService
 
-	_, err = client.WriteLog(ctx, &logs.LogRequest{
-		LogEntry: &logs.Log{
-			Name: requestPayload.Name,
-			Data: requestPayload.Data,
-		},
-	})
+	identity, ok := identityFromContext(r.Context())
+	if !ok {
+		return nil
+	}
 
-	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
+	if entry.UserID != 0 && entry.UserID != int64(identity.UserID) {
+		if identity.Admin {
+			return nil
+		}
+		return errors.New("user_id must be your own")
 	}
 
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Logged via gRPC"
+	entry.UserID = int64(identity.UserID)
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return nil
 }
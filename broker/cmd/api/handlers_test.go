@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The listener counts wrong passwords towards a lockout, and looks for known
+// devices, from entries the logger holds for service auth-service. Entries a
+// caller writes through the broker must never be stored under that service.
+func TestLogActionCannotPoseAsAuthService(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+	}{
+		{"names auth-service", "auth-service"},
+		{"names another service", "billing"},
+		{"names no service", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored LogPayload
+
+			logger := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewDecoder(r.Body).Decode(&stored)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(`{"error":false,"message":"logged"}`))
+			}))
+			defer logger.Close()
+
+			app := testApp(t, logger.URL)
+
+			entry := LogPayload{
+				Name:     "login.failure",
+				Data:     "Wrong password",
+				Severity: "WARNING",
+				Service:  tt.service,
+				UserID:   7,
+				Fields:   map[string]any{"reason": "bad_password", "email": "victim@example.com"},
+			}
+
+			rec := submit(t, app, RequestPayload{Action: "log", Log: entry}, Identity{UserID: 7})
+
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+			}
+
+			if stored.Service != callerService {
+				t.Errorf("stored service = %q, want %q", stored.Service, callerService)
+			}
+		})
+	}
+}
+
+// testApp returns a broker that logs over HTTP to loggerURL
+func testApp(t *testing.T, loggerURL string) *Config {
+	t.Helper()
+
+	breakers := BreakerSettings{Threshold: 5, Cooldown: time.Minute, RetryBaseDelay: time.Millisecond}
+
+	logger, err := NewLoggerClient(loggerURL, "127.0.0.1:0", "127.0.0.1:0", time.Second,
+		breakers.downstream("logger-http"), breakers.downstream("logger-rpc"), breakers.downstream("logger-grpc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(logger.Close)
+
+	return &Config{
+		Settings: Settings{LogTransports: []string{transportHTTP}},
+		Logger:   logger,
+	}
+}
+
+// submit posts payload to /handle as the signed-in caller
+func submit(t *testing.T, app *Config, payload RequestPayload, caller Identity) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/handle", strings.NewReader(string(body)))
+	req = req.WithContext(context.WithValue(req.Context(), identityContextKey, caller))
+
+	rec := httptest.NewRecorder()
+	app.HandleSubmission(rec, req)
+
+	return rec
+}
@@ -13,11 +13,17 @@ type jsonResponse struct {
 	Data    any    `json:"data,omitempty"`
 }
 
+// maxBodyBytes limits request bodies other than mail with attachments
+const maxBodyBytes = 1048576
+
 // read JSON from request body
 func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, v any) error {
-	maxBytes := 1048576
+	return app.readJSONLimit(w, r, v, maxBodyBytes)
+}
 
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+// read JSON from a request body of up to maxBytes
+func (app *Config) readJSONLimit(w http.ResponseWriter, r *http.Request, v any, maxBytes int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 	dec := json.NewDecoder(r.Body)
 	err := dec.Decode(v)
 
@@ -74,3 +80,15 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
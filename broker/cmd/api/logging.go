@@ -0,0 +1,174 @@
+package main
+
+import (
+	"broker/event"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Transports the broker can write log entries with
+const (
+	transportHTTP   = "http"
+	transportRPC    = "rpc"
+	transportGRPC   = "grpc"
+	transportRabbit = "rabbitmq"
+)
+
+var logTransports = []string{transportHTTP, transportRPC, transportGRPC, transportRabbit}
+
+// rejectedError is a log entry the logger refused. Every transport would refuse
+// it the same way, so it is not retried on the next one.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
+
+// transportFailure records a transport that was tried and failed
+type transportFailure struct {
+	Transport string `json:"transport"`
+	Error     string `json:"error"`
+
+	err error
+}
+
+// redact replaces the error, which can name internal addresses, with a
+// generic reason for callers who aren't admins
+func (f *transportFailure) redact() {
+	switch {
+	case errors.Is(f.err, context.DeadlineExceeded):
+		f.Error = "timed out"
+	case errors.Is(f.err, errCircuitOpen):
+		f.Error = errCircuitOpen.Error()
+	default:
+		f.Error = "unavailable"
+	}
+}
+
+// logResult tells the caller how an entry was written
+type logResult struct {
+	Transport string             `json:"transport"`
+	Fallbacks []transportFailure `json:"fallbacks,omitempty"`
+}
+
+func validTransport(transport string) bool {
+	for _, t := range logTransports {
+		if t == transport {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transportChain puts the preferred transport, if any, ahead of the configured chain
+func (app *Config) transportChain(preferred string) ([]string, error) {
+	if preferred == "" {
+		return app.Settings.LogTransports, nil
+	}
+
+	if !validTransport(preferred) {
+		return nil, fmt.Errorf("unknown transport %q, must be one of %s", preferred, strings.Join(logTransports, ", "))
+	}
+
+	chain := []string{preferred}
+	for _, t := range app.Settings.LogTransports {
+		if t != preferred {
+			chain = append(chain, t)
+		}
+	}
+
+	return chain, nil
+}
+
+// writeLog tries each transport in the chain until one takes the entry. It stops
+// early if the logger rejects the entry itself.
+func (app *Config) writeLog(ctx context.Context, chain []string, entry LogPayload) (logResult, error) {
+	var result logResult
+
+	for _, transport := range chain {
+		err := app.logVia(ctx, transport, entry)
+		if err == nil {
+			result.Transport = transport
+			return result, nil
+		}
+
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			return result, err
+		}
+
+		result.Fallbacks = append(result.Fallbacks, transportFailure{Transport: transport, Error: err.Error(), err: err})
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return result, errors.New("no log transport is available")
+}
+
+func (app *Config) logVia(ctx context.Context, transport string, entry LogPayload) error {
+	switch transport {
+	case transportHTTP:
+		return app.Logger.Log(ctx, entry)
+	case transportRPC:
+		_, err := app.Logger.LogRPC(ctx, entry)
+		return err
+	case transportGRPC:
+		return app.Logger.LogGRPC(ctx, entry)
+	case transportRabbit:
+		return app.pushToQueue(event.FamilyLog, entry)
+	default:
+		return fmt.Errorf("unknown transport %q", transport)
+	}
+}
+
+// logItem writes entry with the preferred transport, falling back along the
+// configured chain, and reports the transport that was used
+func (app *Config) logItem(w http.ResponseWriter, r *http.Request, preferred string, entry LogPayload) {
+	chain, err := app.transportChain(preferred)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := app.writeLog(r.Context(), chain, entry)
+
+	// like breaker errors on /status, transport errors are only shown to admins
+	if identity, _ := identityFromContext(r.Context()); !identity.Admin {
+		for i := range result.Fallbacks {
+			result.Fallbacks[i].redact()
+		}
+	}
+
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		app.writeJSON(w, http.StatusServiceUnavailable, jsonResponse{
+			Error:   true,
+			Message: err.Error(),
+			Data:    result,
+		})
+		return
+	}
+
+	var payload jsonResponse
+	payload.Error = false
+	payload.Message = "Logged via " + result.Transport
+	payload.Data = result
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"mailmsg"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Modes a mail action can be sent in
+const (
+	mailSync  = "sync"
+	mailAsync = "async"
+)
+
+// mailJob is returned when a mail action is queued
+type mailJob struct {
+	JobID string `json:"job_id"`
+}
+
+// QueueMail hands msg to mail-service through RabbitMQ and returns a job id
+// straight away. Delivery is checked with GET /mail/{id}.
+func (app *Config) QueueMail(w http.ResponseWriter, r *http.Request, msg MailPayload) {
+	err := msg.validate(app.Attachments)
+	if err != nil {
+		app.invalidMail(w, err)
+		return
+	}
+
+	jobID, err := newJobID()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Emitter.PushMail(jobID, body)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	// the worker records the job itself if this fails, once it picks it up
+	err = app.Mailer.Record(r.Context(), jobID, msg)
+	if err != nil {
+		log.Printf("could not record queued mail %s: %v", jobID, err)
+	}
+
+	var payload jsonResponse
+	payload.Error = false
+	payload.Message = "Mail to " + strings.Join(msg.Recipients(), ", ") + " queued"
+	payload.Data = mailJob{JobID: jobID}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// MailStatus relays mail-service's delivery status for a message, whether it
+// was queued or sent straight away
+func (app *Config) MailStatus(w http.ResponseWriter, r *http.Request) {
+	resp, err := app.Mailer.Message(r.Context(), chi.URLParam(r, "id"))
+
+	if err != nil {
+		app.downstreamFailed(w, err)
+		return
+	}
+
+	app.writeJSON(w, resp.StatusCode, resp.Body)
+}
+
+// validate checks the addressing and attachments, returning an
+// *mailmsg.InvalidError that lists each problem found
+func (m MailPayload) validate(limits mailmsg.Limits) error {
+	return mailmsg.Validate(m.Addressing, m.Attachments, limits)
+}
+
+// invalidMail answers a mail action that failed validation, listing each problem
+func (app *Config) invalidMail(w http.ResponseWriter, err error) {
+	var invalid *mailmsg.InvalidError
+	if !errors.As(err, &invalid) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var payload jsonResponse
+	payload.Error = true
+	payload.Message = err.Error()
+	payload.Data = invalid.Problems
+
+	app.writeJSON(w, http.StatusBadRequest, payload)
+}
+
+// newJobID returns a random 128-bit job id
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
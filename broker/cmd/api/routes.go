@@ -22,9 +22,11 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
+	mux.With(app.authenticate, app.requireAuth).Get("/status", app.Status)
 	mux.Post("/", app.Broker)
-	mux.Post("/handle", app.HandleSubmission)
-	mux.Post("/log-grpc", app.logItemViaGRPC)
+	mux.With(app.authenticate).Post("/handle", app.HandleSubmission)
+	mux.With(app.authenticate, app.requireAuth).Post("/log-grpc", app.logItemViaGRPC)
+	mux.With(app.authenticate, app.requireAuth).Get("/mail/{id}", app.MailStatus)
 
 	return mux
 }
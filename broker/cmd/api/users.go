@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+type UserPayload struct {
+	ID        int     `json:"id,omitempty"`
+	Email     *string `json:"email,omitempty"`
+	FirstName *string `json:"first_name,omitempty"`
+	LastName  *string `json:"last_name,omitempty"`
+	Password  string  `json:"password,omitempty"`
+	Active    *int    `json:"active,omitempty"`
+	Page      int     `json:"page,omitempty"`
+	PageSize  int     `json:"page_size,omitempty"`
+}
+
+// ManageUser forwards a user management action to auth-service and relays its
+// response, including validation errors and status codes, back to the caller.
+// Only admins may manage users.
+func (app *Config) ManageUser(w http.ResponseWriter, r *http.Request, action string, u UserPayload) {
+	if identity, ok := identityFromContext(r.Context()); !ok || !identity.Admin {
+		app.errorJSON(w, errors.New("admin access required"), http.StatusForbidden)
+		return
+	}
+
+	method, path, body, err := userRequest(action, u)
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	resp, err := app.Auth.User(r.Context(), method, path, body)
+
+	if err != nil {
+		app.downstreamFailed(w, err)
+		return
+	}
+
+	app.writeJSON(w, resp.StatusCode, resp.Body)
+}
+
+// build the auth-service request for a user action
+func userRequest(action string, u UserPayload) (method, path string, body any, err error) {
+	if action != "list-users" && action != "create-user" && u.ID < 1 {
+		return "", "", nil, errors.New("user id is required")
+	}
+
+	switch action {
+	case "list-users":
+		q := url.Values{}
+		if u.Page > 0 {
+			q.Set("page", strconv.Itoa(u.Page))
+		}
+		if u.PageSize > 0 {
+			q.Set("page_size", strconv.Itoa(u.PageSize))
+		}
+		path = "/users"
+		if len(q) > 0 {
+			path += "?" + q.Encode()
+		}
+		return http.MethodGet, path, nil, nil
+	case "get-user":
+		return http.MethodGet, fmt.Sprintf("/users/%d", u.ID), nil, nil
+	case "create-user":
+		return http.MethodPost, "/users", map[string]any{
+			"email":      u.Email,
+			"first_name": u.FirstName,
+			"last_name":  u.LastName,
+			"password":   u.Password,
+			"active":     u.Active,
+		}, nil
+	case "update-user":
+		return http.MethodPut, fmt.Sprintf("/users/%d", u.ID), map[string]any{
+			"email":      u.Email,
+			"first_name": u.FirstName,
+			"last_name":  u.LastName,
+			"active":     u.Active,
+		}, nil
+	case "deactivate-user":
+		return http.MethodPost, fmt.Sprintf("/users/%d/deactivate", u.ID), nil, nil
+	case "unlock-user":
+		return http.MethodPost, fmt.Sprintf("/users/%d/unlock", u.ID), nil, nil
+	case "reset-password":
+		return http.MethodPost, fmt.Sprintf("/users/%d/reset-password", u.ID), map[string]any{
+			"password": u.Password,
+		}, nil
+	default:
+		return "", "", nil, errors.New("invalid action")
+	}
+}
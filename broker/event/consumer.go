@@ -41,8 +41,13 @@ func (consumer *Consumer) setup() error {
 
 // push messages to the queue
 type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string         `json:"name"`
+	Data     string         `json:"data"`
+	Severity string         `json:"severity,omitempty"`
+	Service  string         `json:"service,omitempty"`
+	TraceID  string         `json:"trace_id,omitempty"`
+	UserID   int64          `json:"user_id,omitempty"`
+	Fields   map[string]any `json:"fields,omitempty"`
 }
 
 func (consumer *Consumer) Listen(topics []string) error {
@@ -1,17 +1,48 @@
 package event
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"rabbit"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// DefaultPoolSize is the number of confirm-mode channels an Emitter keeps open
+const DefaultPoolSize = 8
+
+// publishTimeout bounds how long Push waits for a free channel and for RabbitMQ
+// to confirm the message
+const publishTimeout = 5 * time.Second
+
+var (
+	// ErrNacked means RabbitMQ refused responsibility for a message
+	ErrNacked = errors.New("message was not accepted by rabbitmq")
+	// ErrUnroutable means no queue was bound for the message's routing key
+	ErrUnroutable = errors.New("message could not be routed to any queue")
+)
+
+// Emitter publishes events on the logs_topic exchange, and mail jobs on the mail
+// exchange, over a pool of long-lived channels in confirm mode, so Push and
+// PushMail only return once RabbitMQ has taken the message
 type Emitter struct {
-	connection *amqp.Connection
+	connection *rabbit.Connection
+	pool       chan *confirmChannel
 }
 
-func (e *Emitter) setup() error {
-	channel, err := e.connection.Channel()
+// confirmChannel is a channel in confirm mode with one publish in flight at a
+// time, so the next confirm or return on it always belongs to that publish
+type confirmChannel struct {
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+// setupExchange declares the exchanges events and mail jobs are published on
+func setupExchange(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
 
 	if err != nil {
 		return err
@@ -19,40 +50,186 @@ func (e *Emitter) setup() error {
 
 	defer channel.Close()
 
-	return declareExchange(channel)
+	err = declareExchange(channel)
+	if err != nil {
+		return err
+	}
+
+	return rabbit.DeclareMailQueue(channel)
 }
 
-func (e *Emitter) Push(event string, severity string) error {
+// open opens a channel in confirm mode, waiting until ctx is done for the
+// connection to come back if it is being re-established
+func (e *Emitter) open(ctx context.Context) (*confirmChannel, error) {
+	err := e.connection.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	channel, err := e.connection.Channel()
+	if err != nil {
+		return nil, err
+	}
 
+	err = channel.Confirm(false)
+	if err != nil {
+		channel.Close()
+		return nil, err
+	}
+
+	return &confirmChannel{
+		channel:  channel,
+		confirms: channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  channel.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+// acquire takes a channel from the pool, opening one if the slot is empty or its
+// channel has closed, as they all do when the connection drops. It waits for a
+// free slot until ctx is done.
+func (e *Emitter) acquire(ctx context.Context) (*confirmChannel, error) {
+	var c *confirmChannel
+
+	select {
+	case c = <-e.pool:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if c != nil && !c.channel.IsClosed() {
+		return c, nil
+	}
+
+	c, err := e.open(ctx)
+	if err != nil {
+		e.pool <- nil
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// release hands a channel back to the pool. Channels left in an unknown state are
+// closed and their slot is reopened on next use.
+func (e *Emitter) release(c *confirmChannel, healthy bool) {
+	if !healthy {
+		c.channel.Close()
+		c = nil
+	}
+
+	e.pool <- c
+}
+
+// Push publishes an event on the logs_topic exchange and waits for RabbitMQ to
+// confirm it. Messages are persistent and mandatory, so an event that no queue is
+// bound for is reported as ErrUnroutable rather than silently dropped. Build
+// routingKey with RoutingKey so that listeners can bind by family and severity.
+func (e *Emitter) Push(event string, routingKey string) error {
+	return e.publish("logs_topic", routingKey, amqp.Publishing{
+		ContentType: "text/plain",
+		Body:        []byte(event),
+	})
+}
+
+// PushMail queues a JSON encoded mail job for mail-service under the given job id
+// and waits for RabbitMQ to confirm it
+func (e *Emitter) PushMail(jobID string, job []byte) error {
+	return e.publish(rabbit.MailExchange, rabbit.MailRoutingKey, amqp.Publishing{
+		ContentType: "application/json",
+		MessageId:   jobID,
+		Body:        job,
+	})
+}
+
+// publish sends msg as a persistent, mandatory message and waits for its confirm
+func (e *Emitter) publish(exchange, routingKey string, msg amqp.Publishing) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	c, err := e.acquire(ctx)
 	if err != nil {
 		return err
 	}
 
-	defer channel.Close()
+	msg.DeliveryMode = amqp.Persistent
+	msg.Timestamp = time.Now()
 
-	log.Println("Pushing to channel!")
-	return channel.Publish(
-		"logs_topic",
-		severity,
-		false,
+	err = c.channel.PublishWithContext(
+		ctx,
+		exchange,
+		routingKey,
+		true,
 		false,
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(event),
-		},
+		msg,
 	)
+	if err != nil {
+		e.release(c, false)
+		return err
+	}
+
+	var confirm amqp.Confirmation
+
+	select {
+	case confirm = <-c.confirms:
+	case <-ctx.Done():
+		// the confirm may still arrive, so this channel can't be trusted to line up again
+		e.release(c, false)
+		return fmt.Errorf("waiting for publish confirm: %w", ctx.Err())
+	}
+
+	if confirm.DeliveryTag == 0 {
+		// the notify channel was closed along with the AMQP channel
+		e.release(c, false)
+		return errors.New("channel closed before the message was confirmed")
+	}
+
+	// RabbitMQ sends basic.return before the ack, so any return is already waiting
+	select {
+	case ret := <-c.returns:
+		e.release(c, true)
+		return fmt.Errorf("%w: %s %s", ErrUnroutable, ret.RoutingKey, ret.ReplyText)
+	default:
+	}
+
+	e.release(c, true)
+
+	if !confirm.Ack {
+		return ErrNacked
+	}
+
+	return nil
 }
 
-func NewEventEmitter(conn *amqp.Connection) (Emitter, error) {
-	emitter := Emitter{
+// Close closes the pooled channels. The connection is left open.
+func (e *Emitter) Close() {
+	for i := 0; i < cap(e.pool); i++ {
+		if c := <-e.pool; c != nil {
+			c.channel.Close()
+		}
+	}
+}
+
+// NewEventEmitter declares the exchange and returns an Emitter with a pool of up
+// to poolSize channels, opened as they are first needed
+func NewEventEmitter(conn *rabbit.Connection, poolSize int) (*Emitter, error) {
+	if poolSize < 1 {
+		poolSize = DefaultPoolSize
+	}
+
+	emitter := &Emitter{
 		connection: conn,
+		pool:       make(chan *confirmChannel, poolSize),
+	}
+
+	for i := 0; i < poolSize; i++ {
+		emitter.pool <- nil
 	}
 
-	err := emitter.setup()
+	// declared again whenever the connection is re-established
+	err := conn.OnConnect(setupExchange)
 
 	if err != nil {
-		return Emitter{}, err
+		return nil, err
 	}
 
 	return emitter, nil
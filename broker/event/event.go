@@ -1,9 +1,27 @@
 package event
 
 import (
+	"strings"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// Topic families published on the logs_topic exchange. Routing keys take the
+// form <family>.<SEVERITY>, e.g. log.ERROR or auth.WARNING.
+const (
+	FamilyLog  = "log"
+	FamilyAuth = "auth"
+	FamilyMail = "mail"
+)
+
+// Severities used as the second segment of a routing key
+const (
+	SeverityDebug   = "DEBUG"
+	SeverityInfo    = "INFO"
+	SeverityWarning = "WARNING"
+	SeverityError   = "ERROR"
+)
+
 func declareExchange(ch *amqp.Channel) error {
 	return ch.ExchangeDeclare(
 		"logs_topic", // name
@@ -26,3 +44,20 @@ func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
 		nil,   // arguments
 	)
 }
+
+// RoutingKey builds the routing key for an event of the given family and severity.
+// Unknown or empty severities are published as INFO.
+func RoutingKey(family, severity string) string {
+	switch strings.ToUpper(severity) {
+	case SeverityDebug:
+		severity = SeverityDebug
+	case SeverityWarning, "WARN":
+		severity = SeverityWarning
+	case SeverityError:
+		severity = SeverityError
+	default:
+		severity = SeverityInfo
+	}
+
+	return family + "." + severity
+}
@@ -3,14 +3,31 @@ module broker
 go 1.19
 
 require (
-	github.com/go-chi/chi/v5 v5.0.7 // indirect
-	github.com/go-chi/cors v1.2.1 // indirect
+	github.com/go-chi/chi/v5 v5.0.7
+	github.com/go-chi/cors v1.2.1
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/rabbitmq/amqp091-go v1.5.0
+	google.golang.org/grpc v1.50.1
+	google.golang.org/protobuf v1.27.1
+)
+
+require (
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/rabbitmq/amqp091-go v1.5.0 // indirect
 	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4 // indirect
 	golang.org/x/sys v0.0.0-20210510120138-977fb7262007 // indirect
 	golang.org/x/text v0.3.3 // indirect
 	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
-	google.golang.org/grpc v1.50.1 // indirect
-	google.golang.org/protobuf v1.27.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+require (
+	config v0.0.0
+	mailmsg v0.0.0
+	rabbit v0.0.0
+)
+
+replace config => ../config
+
+replace mailmsg => ../mailmsg
+
+replace rabbit => ../rabbit
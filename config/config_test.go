@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testSettings struct {
+	Port     string        `yaml:"port" env:"TEST_PORT" default:"80"`
+	Timeout  time.Duration `yaml:"timeout" env:"TEST_TIMEOUT" default:"10s" validate:"positive"`
+	Hosts    []string      `yaml:"hosts" env:"TEST_HOSTS" default:"a,b"`
+	Secret   string        `yaml:"secret" env:"TEST_SECRET" required:"true"`
+	Breaker  testBreaker   `yaml:"breaker"`
+	Optional string        `yaml:"optional" env:"TEST_OPTIONAL"`
+}
+
+type testBreaker struct {
+	Threshold int `yaml:"threshold" env:"TEST_THRESHOLD" default:"5"`
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		want testSettings
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"TEST_SECRET": "s"},
+			want: testSettings{Port: "80", Timeout: 10 * time.Second, Hosts: []string{"a", "b"}, Secret: "s", Breaker: testBreaker{Threshold: 5}},
+		},
+		{
+			name: "file over defaults",
+			yaml: "port: \"8080\"\nsecret: from-file\nhosts: [c]\nbreaker:\n  threshold: 7\n",
+			want: testSettings{Port: "8080", Timeout: 10 * time.Second, Hosts: []string{"c"}, Secret: "from-file", Breaker: testBreaker{Threshold: 7}},
+		},
+		{
+			name: "env over file",
+			yaml: "port: \"8080\"\nsecret: from-file\nbreaker:\n  threshold: 7\n",
+			env:  map[string]string{"TEST_PORT": "9090", "TEST_SECRET": "from-env", "TEST_THRESHOLD": "9", "TEST_HOSTS": "d, e"},
+			want: testSettings{Port: "9090", Timeout: 10 * time.Second, Hosts: []string{"d", "e"}, Secret: "from-env", Breaker: testBreaker{Threshold: 9}},
+		},
+		{
+			name: "empty env keeps file",
+			yaml: "port: \"8080\"\nsecret: from-file\n",
+			env:  map[string]string{"TEST_PORT": ""},
+			want: testSettings{Port: "8080", Timeout: 10 * time.Second, Hosts: []string{"a", "b"}, Secret: "from-file", Breaker: testBreaker{Threshold: 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.yaml, tt.env)
+
+			var got testSettings
+			if err := Load(&got); err != nil {
+				t.Fatalf("Load() = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		env  map[string]string
+		want int
+	}{
+		{"required missing", "", nil, 1},
+		{"malformed env", "", map[string]string{"TEST_SECRET": "s", "TEST_TIMEOUT": "soon"}, 1},
+		{"not positive", "", map[string]string{"TEST_SECRET": "s", "TEST_TIMEOUT": "-1s"}, 1},
+		{"unknown file key", "secret: s\nprot: \"8080\"\n", nil, 1},
+		{"every problem at once", "", map[string]string{"TEST_TIMEOUT": "soon", "TEST_THRESHOLD": "many"}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.yaml, tt.env)
+
+			var got testSettings
+			err := Load(&got)
+
+			var cfgErr *Error
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("Load() = %v, want an *Error", err)
+			}
+
+			if len(cfgErr.Problems) != tt.want {
+				t.Errorf("problems = %q, want %d", cfgErr.Problems, tt.want)
+			}
+		})
+	}
+}
+
+// setEnv points CONFIG_FILE at a file holding contents, if any, and sets env.
+// Every variable the test settings read is cleared first.
+func setEnv(t *testing.T, contents string, env map[string]string) {
+	t.Helper()
+
+	for _, key := range []string{FileEnv, "TEST_PORT", "TEST_TIMEOUT", "TEST_HOSTS", "TEST_SECRET", "TEST_THRESHOLD", "TEST_OPTIONAL"} {
+		t.Setenv(key, "")
+	}
+
+	if contents != "" {
+		path := filepath.Join(t.TempDir(), "settings.yaml")
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(FileEnv, path)
+	}
+
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
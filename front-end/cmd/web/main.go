@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -12,11 +18,33 @@ func main() {
 		render(w, "test.page.gohtml")
 	})
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Addr: ":80"}
+
+	drained := make(chan struct{})
+
+	go func() {
+		defer close(drained)
+
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Println("could not drain http requests:", err)
+		}
+	}()
+
 	fmt.Println("Starting front end service on port 80")
-	err := http.ListenAndServe(":80", nil)
-	if err != nil {
+	err := srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Panic(err)
 	}
+
+	<-drained
 }
 
 func render(w http.ResponseWriter, t string) {
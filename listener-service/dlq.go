@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"listener/event"
+	"os"
+	"rabbit"
+)
+
+const dlqUsage = `usage: listenerApp dlq <command> [-n count]
+
+commands:
+  list     print dead-lettered messages without removing them
+  replay   publish dead-lettered messages back to logs_topic`
+
+// deadLetterCommand runs one of the dlq subcommands against the dead-letter queue
+func deadLetterCommand(conn *rabbit.Connection, args []string) error {
+	if len(args) == 0 {
+		return errors.New(dlqUsage)
+	}
+
+	flags := flag.NewFlagSet("dlq "+args[0], flag.ContinueOnError)
+	count := flags.Int("n", 20, "maximum number of messages")
+
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		letters, err := event.InspectDeadLetters(conn, *count)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		for _, letter := range letters {
+			if err := enc.Encode(letter); err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "%d message(s) on %s\n", len(letters), event.DeadLetterQueue)
+		return nil
+	case "replay":
+		replayed, err := event.ReplayDeadLetters(conn, *count)
+		fmt.Fprintf(os.Stderr, "replayed %d message(s) from %s\n", replayed, event.DeadLetterQueue)
+		return err
+	default:
+		return errors.New(dlqUsage)
+	}
+}
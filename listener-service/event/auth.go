@@ -0,0 +1,238 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Auth event names published by auth-service on the auth.* topics
+const (
+	loginSuccess  = "login.success"
+	loginFailure  = "login.failure"
+	passwordReset = "password.reset"
+)
+
+// LockoutPolicy locks an account once Threshold wrong passwords have been seen
+// within Window. The lock lasts for Duration, which should be at least Window so
+// that the failures which caused a lock have aged out by the time it lifts.
+type LockoutPolicy struct {
+	Threshold int
+	Window    time.Duration
+	Duration  time.Duration
+}
+
+// authEvent records an auth event in the audit log and triggers its side effects
+func (consumer *Consumer) authEvent(entry Payload) error {
+	switch entry.Name {
+	case loginSuccess:
+		return consumer.loginSucceeded(entry)
+	case loginFailure:
+		return consumer.loginFailed(entry)
+	case passwordReset:
+		err := consumer.logEvent(entry)
+		if err != nil {
+			return err
+		}
+
+		return consumer.notifyUser(entry, "Your password was changed", fmt.Sprintf(
+			"The password for your account was changed at %s. If this wasn't you, contact support immediately.",
+			time.Now().UTC().Format(time.RFC1123)))
+	default:
+		return consumer.logEvent(entry)
+	}
+}
+
+// loginSucceeded audits a sign-in and emails the user when it comes from an IP
+// address and browser we haven't seen them sign in from before
+func (consumer *Consumer) loginSucceeded(entry Payload) error {
+	known, err := consumer.knownDevice(entry)
+	if err != nil {
+		return err
+	}
+
+	err = consumer.logEvent(entry)
+	if err != nil {
+		return err
+	}
+
+	if known {
+		return nil
+	}
+
+	return consumer.notifyUser(entry, "New sign-in to your account", fmt.Sprintf(
+		"Your account was signed in to at %s from %v (%v). If this wasn't you, reset your password.",
+		time.Now().UTC().Format(time.RFC1123), entry.Fields["ip"], entry.Fields["user_agent"]))
+}
+
+// loginFailed audits a failed sign-in and locks the account when the lockout
+// threshold is reached. Only wrong passwords for real users count.
+func (consumer *Consumer) loginFailed(entry Payload) error {
+	err := consumer.logEvent(entry)
+	if err != nil {
+		return err
+	}
+
+	policy := consumer.options.Lockout
+
+	if policy.Threshold <= 0 || entry.UserID == 0 || entry.Fields["reason"] != "bad_password" {
+		return nil
+	}
+
+	failures, err := consumer.recentAuthEvents(entry.UserID, loginFailure, time.Now().Add(-policy.Window), policy.Threshold*2)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, failure := range failures {
+		if failure.Fields["reason"] == "bad_password" {
+			count++
+		}
+	}
+
+	if count < policy.Threshold {
+		return nil
+	}
+
+	err = consumer.lockUser(entry.UserID, policy.Duration)
+	if err != nil {
+		return err
+	}
+
+	return consumer.notifyUser(entry, "Your account has been locked", fmt.Sprintf(
+		"After %d failed sign-in attempts your account has been locked for %s.",
+		count, policy.Duration))
+}
+
+// knownDevice reports whether the user has signed in before from the same IP and
+// user agent. The entry itself is skipped, since a retry finds it already logged.
+func (consumer *Consumer) knownDevice(entry Payload) (bool, error) {
+	if entry.UserID == 0 {
+		return true, nil
+	}
+
+	previous, err := consumer.recentAuthEvents(entry.UserID, loginSuccess, time.Time{}, 100)
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range previous {
+		if entry.EventID != "" && p.EventID == entry.EventID {
+			continue
+		}
+
+		if p.Fields["ip"] == entry.Fields["ip"] && p.Fields["user_agent"] == entry.Fields["user_agent"] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// recentAuthEvents queries the audit log in logger-service for a user's events
+func (consumer *Consumer) recentAuthEvents(userID int64, name string, since time.Time, limit int) ([]Payload, error) {
+	q := url.Values{}
+	q.Set("name", name)
+	q.Set("service", "auth-service")
+	q.Set("user_id", strconv.FormatInt(userID, 10))
+	q.Set("limit", strconv.Itoa(limit))
+	if !since.IsZero() {
+		q.Set("from", since.UTC().Format(time.RFC3339))
+	}
+
+	resp, err := httpClient.Get(consumer.options.LoggerURL + "/logs?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("logger service returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Logs []Payload `json:"logs"`
+		} `json:"data"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	return body.Data.Logs, nil
+}
+
+func (consumer *Consumer) lockUser(userID int64, duration time.Duration) error {
+	body, err := json.Marshal(map[string]string{"duration": duration.String()})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("X-Service-Token", consumer.options.ServiceToken)
+
+	resp, err := postJSON(fmt.Sprintf("%s/users/%d/lock", consumer.options.AuthURL, userID), body, header)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth service returned %s locking user %d", resp.Status, userID)
+	}
+
+	return nil
+}
+
+// notifyUser emails the user an auth event is about, if we know their address
+func (consumer *Consumer) notifyUser(entry Payload, subject, message string) error {
+	to, _ := entry.Fields["email"].(string)
+	if to == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"to":      to,
+		"subject": subject,
+		"message": message,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := postJSON(consumer.options.MailerURL+"/send", body, nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("mail service returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+func postJSON(url string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	return httpClient.Do(req)
+}
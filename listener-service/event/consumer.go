@@ -2,25 +2,95 @@ package event
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"rabbit"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// httpClient makes every call to the other services. Its timeout keeps a service
+// that stops answering from holding a worker forever.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
 // receive messages from the queue
 type Consumer struct {
-	conn      *amqp.Connection
+	conn      *rabbit.Connection
 	queueName string
+	options   Options
+}
+
+// Options controls how deliveries are processed
+type Options struct {
+	// Workers is the number of deliveries handled concurrently, and the prefetch count
+	Workers int
+	// MaxRetries is how many times a failed delivery is retried before it is dead-lettered
+	MaxRetries int
+	// RetryDelay is how long a failed delivery waits in the retry queue
+	RetryDelay time.Duration
+	// Lockout controls when repeated failed logins lock an account
+	Lockout LockoutPolicy
+	// LoggerURL, AuthURL and MailerURL are the base URLs of the services events are
+	// delivered to
+	LoggerURL string
+	AuthURL   string
+	MailerURL string
+	// ServiceToken is sent to auth-service, which requires it to lock accounts
+	ServiceToken string
+	// QueueName selects a durable queue shared by every listener using the same
+	// name. When empty, each listener gets its own exclusive queue that is
+	// deleted when it disconnects.
+	QueueName string
+}
+
+// DefaultOptions are used for any option left at its zero value
+var DefaultOptions = Options{
+	Workers:    10,
+	MaxRetries: 5,
+	RetryDelay: 10 * time.Second,
+	Lockout: LockoutPolicy{
+		Threshold: 5,
+		Window:    15 * time.Minute,
+		Duration:  15 * time.Minute,
+	},
+	LoggerURL: "http://logger-service",
+	AuthURL:   "http://auth-service",
+	MailerURL: "http://mailer-service",
 }
 
-func NewConsumer(conn *amqp.Connection) (Consumer, error) {
+func NewConsumer(conn *rabbit.Connection, options Options) (Consumer, error) {
+	if options.Workers <= 0 {
+		options.Workers = DefaultOptions.Workers
+	}
+	if options.MaxRetries < 0 {
+		options.MaxRetries = DefaultOptions.MaxRetries
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = DefaultOptions.RetryDelay
+	}
+	if options.LoggerURL == "" {
+		options.LoggerURL = DefaultOptions.LoggerURL
+	}
+	if options.AuthURL == "" {
+		options.AuthURL = DefaultOptions.AuthURL
+	}
+	if options.MailerURL == "" {
+		options.MailerURL = DefaultOptions.MailerURL
+	}
+
 	consumer := Consumer{
-		conn: conn,
+		conn:    conn,
+		options: options,
 	}
 
-	err := consumer.setup()
+	// declared again whenever the connection is re-established
+	err := conn.OnConnect(setupExchanges)
 
 	if err != nil {
 		return Consumer{}, err
@@ -29,23 +99,74 @@ func NewConsumer(conn *amqp.Connection) (Consumer, error) {
 	return consumer, nil
 }
 
-func (consumer *Consumer) setup() error {
-	channel, err := consumer.conn.Channel()
+// setupExchanges declares the exchanges and dead-letter queue every listener needs
+func setupExchanges(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
 
 	if err != nil {
 		return err
 	}
 
-	return declareExchange(channel)
+	defer channel.Close()
+
+	err = declareExchange(channel)
+	if err != nil {
+		return err
+	}
+
+	return declareDeadLetterQueue(channel)
 }
 
 // push messages to the queue
 type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string         `json:"name"`
+	Data     string         `json:"data"`
+	Severity string         `json:"severity,omitempty"`
+	Service  string         `json:"service,omitempty"`
+	TraceID  string         `json:"trace_id,omitempty"`
+	UserID   int64          `json:"user_id,omitempty"`
+	Fields   map[string]any `json:"fields,omitempty"`
+	// EventID is the message id of the delivery, which the logger uses to write
+	// an event only once however often it is retried
+	EventID string `json:"event_id,omitempty"`
+}
+
+// consumerTag identifies the listener's subscription on its channel so that it
+// can be cancelled on shutdown
+const consumerTag = "listener"
+
+// Listen consumes events bound by topics until ctx is done or the connection is
+// closed. When the connection drops, it waits for it to come back and then declares
+// its queues and bindings again and carries on consuming. Once ctx is done, Listen
+// stops taking deliveries and returns after the ones in flight have been settled.
+func (consumer *Consumer) Listen(ctx context.Context, topics []string) error {
+	for {
+		err := consumer.conn.Wait(ctx)
+		if ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = consumer.consume(ctx, topics)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		log.Println("stopped consuming:", err)
+
+		// don't spin if the connection is up but the queues can't be declared
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
 }
 
-func (consumer *Consumer) Listen(topics []string) error {
+// consume declares the work queue and consumes from it until the channel closes
+// or ctx is done
+func (consumer *Consumer) consume(ctx context.Context, topics []string) error {
 	ch, err := consumer.conn.Channel()
 	if err != nil {
 		return err
@@ -53,7 +174,14 @@ func (consumer *Consumer) Listen(topics []string) error {
 
 	defer ch.Close()
 
-	q, err := declareRandomQueue(ch)
+	var q amqp.Queue
+
+	shared := consumer.options.QueueName != ""
+	if shared {
+		q, err = declareDurableQueue(ch, consumer.options.QueueName)
+	} else {
+		q, err = declareRandomQueue(ch)
+	}
 	if err != nil {
 		return err
 	}
@@ -72,68 +200,200 @@ func (consumer *Consumer) Listen(topics []string) error {
 		}
 	}
 
+	_, err = declareRetryQueue(ch, q.Name, consumer.options.RetryDelay, !shared)
+	if err != nil {
+		return err
+	}
+
+	consumer.queueName = q.Name
+
+	err = ch.Qos(consumer.options.Workers, 0, false)
+	if err != nil {
+		return err
+	}
+
+	pubCh, err := consumer.conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	defer pubCh.Close()
+
+	pub, err := newPublisher(pubCh)
+	if err != nil {
+		return err
+	}
+
 	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		true,   // auto-acknowledge
-		false,  // exclusive
-		false,  // no-local/internal
-		false,  // no-wait
-		nil,    // args
+		q.Name,      // queue
+		consumerTag, // consumer
+		false,       // auto-acknowledge
+		false,       // exclusive
+		false,       // no-local/internal
+		false,       // no-wait
+		nil,         // args
 	)
 	if err != nil {
 		return err
 	}
 
-	forever := make(chan bool)
+	var wg sync.WaitGroup
+
+	// a fixed pool of workers; prefetch stops the broker sending more than they can hold
+	for i := 0; i < consumer.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range msgs {
+				consumer.process(pub, d)
+			}
+		}()
+	}
 
-	go func() {
-		for d := range msgs {
-			var payload Payload
-			_ = json.Unmarshal(d.Body, &payload)
+	log.Println("Waiting for message on [exchange, queue]: [logs_topic, ", q.Name, "]")
 
-			go handlePayload(payload)
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	// cancelling the subscription closes msgs once the server stops sending, which
+	// lets the workers finish and settle what they already have
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := ch.Cancel(consumerTag, false); err != nil {
+				log.Println("could not cancel consumer:", err)
+			}
+		case <-stopped:
 		}
 	}()
 
-	log.Println("Waiting for message on [exchange, queue]: [logs_topic, ", q.Name, "]")
-	<-forever
+	// msgs is also closed when the channel or connection goes away; unacked
+	// deliveries are redelivered by RabbitMQ
+	wg.Wait()
 
-	return nil
+	return errors.New("delivery channel closed")
 }
 
-func handlePayload(payload Payload) {
-	switch payload.Name {
-	case "log", "event":
-		// log whatever we get
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}
+// process handles one delivery and settles it: acked on success, sent to the retry
+// queue on a transient failure, and dead-lettered once retries are exhausted
+func (consumer *Consumer) process(pub *publisher, d amqp.Delivery) {
+	var payload Payload
+
+	err := json.Unmarshal(d.Body, &payload)
+	if err != nil {
+		consumer.deadLetter(pub, d, fmt.Errorf("could not decode payload: %w", err))
 		return
-	case "auth":
-		err := authEvent(payload)
-		if err != nil {
-			log.Println(err)
+	}
+
+	if payload.EventID == "" {
+		payload.EventID = d.MessageId
+	}
+
+	err = consumer.handlePayload(originalRoutingKey(d), payload)
+	if err == nil {
+		if err := d.Ack(false); err != nil {
+			log.Println("could not ack delivery:", err)
 		}
 		return
-	default:
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || retryCount(d) >= consumer.options.MaxRetries {
+		consumer.deadLetter(pub, d, err)
+		return
+	}
+
+	consumer.retry(pub, d, err)
+}
+
+func (consumer *Consumer) retry(pub *publisher, d amqp.Delivery, cause error) {
+	attempt := retryCount(d) + 1
+
+	log.Printf("delivery failed, retrying in %s (attempt %d of %d): %v",
+		consumer.options.RetryDelay, attempt, consumer.options.MaxRetries, cause)
+
+	err := pub.publish(retryExchange, consumer.queueName, republish(d, amqp.Table{
+		headerRetryCount: int32(attempt),
+		headerRoutingKey: originalRoutingKey(d),
+		headerLastError:  cause.Error(),
+	}))
+
+	consumer.settle(d, err)
+}
+
+func (consumer *Consumer) deadLetter(pub *publisher, d amqp.Delivery, cause error) {
+	log.Println("dead-lettering delivery:", cause)
+
+	err := pub.publish(deadExchange, "", republish(d, amqp.Table{
+		headerRetryCount:  int32(retryCount(d)),
+		headerRoutingKey:  originalRoutingKey(d),
+		headerLastError:   cause.Error(),
+		headerFailedQueue: consumer.queueName,
+		headerFailedAt:    time.Now().UTC().Format(time.RFC3339),
+	}))
+
+	consumer.settle(d, err)
+}
+
+// settle acks a delivery once its copy has been published elsewhere. If the copy
+// could not be published, the delivery is requeued rather than lost.
+func (consumer *Consumer) settle(d amqp.Delivery, publishErr error) {
+	if publishErr != nil {
+		log.Println("could not republish delivery, requeueing:", publishErr)
+		if err := d.Nack(false, true); err != nil {
+			log.Println("could not nack delivery:", err)
 		}
 		return
 	}
+
+	if err := d.Ack(false); err != nil {
+		log.Println("could not ack delivery:", err)
+	}
+}
+
+// permanentError marks a failure that retrying will not fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func (consumer *Consumer) handlePayload(routingKey string, payload Payload) error {
+	family, severity := SplitRoutingKey(routingKey)
+
+	if payload.Severity == "" {
+		payload.Severity = severity
+	}
+
+	if family == FamilyAuth {
+		return consumer.authEvent(payload)
+	}
+
+	switch payload.Name {
+	case "log", "event":
+		// log whatever we get
+		return consumer.logEvent(payload)
+	case "auth":
+		return consumer.authEvent(payload)
+	default:
+		return consumer.logEvent(payload)
+	}
 }
 
-func logEvent(entry Payload) error {
+func (consumer *Consumer) logEvent(entry Payload) error {
 	jsonData, err := json.MarshalIndent(entry, "", "  ")
 
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", "http://logger-service/log", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", consumer.options.LoggerURL+"/log", bytes.NewBuffer(jsonData))
 
 	if err != nil {
 		return err
@@ -141,9 +401,7 @@ func logEvent(entry Payload) error {
 
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 
 	if err != nil {
 		return err
@@ -151,13 +409,13 @@ func logEvent(entry Payload) error {
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		return err
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &permanentError{fmt.Errorf("logger service rejected entry: %s", resp.Status)}
 	}
 
-	return nil
-}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("logger service returned %s", resp.Status)
+	}
 
-func authEvent(entry Payload) error {
 	return nil
 }
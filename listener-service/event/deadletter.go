@@ -0,0 +1,107 @@
+package event
+
+import "rabbit"
+
+// DeadLetter is a message parked on the dead-letter queue
+type DeadLetter struct {
+	RoutingKey string `json:"routing_key"`
+	Retries    int    `json:"retries"`
+	LastError  string `json:"last_error"`
+	FailedAt   string `json:"failed_at"`
+	Body       string `json:"body"`
+}
+
+// InspectDeadLetters returns up to limit messages from the dead-letter queue without
+// removing them. The messages are fetched unacknowledged and go back on the queue
+// when the channel closes.
+func InspectDeadLetters(conn *rabbit.Connection, limit int) ([]DeadLetter, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ch.Close()
+
+	err = declareDeadLetterQueue(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	var letters []DeadLetter
+
+	for len(letters) < limit {
+		d, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		lastError, _ := d.Headers[headerLastError].(string)
+		failedAt, _ := d.Headers[headerFailedAt].(string)
+
+		letters = append(letters, DeadLetter{
+			RoutingKey: originalRoutingKey(d),
+			Retries:    retryCount(d),
+			LastError:  lastError,
+			FailedAt:   failedAt,
+			Body:       string(d.Body),
+		})
+	}
+
+	return letters, nil
+}
+
+// ReplayDeadLetters moves up to limit messages from the dead-letter queue back onto the
+// logs_topic exchange with their original routing keys and a fresh retry budget. It
+// returns the number of messages replayed.
+func ReplayDeadLetters(conn *rabbit.Connection, limit int) (int, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+
+	defer ch.Close()
+
+	err = declareDeadLetterQueue(ch)
+	if err != nil {
+		return 0, err
+	}
+
+	pub, err := newPublisher(ch)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+
+	for replayed < limit {
+		d, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		msg := republish(d, nil)
+		for _, header := range []string{headerRetryCount, headerRoutingKey, headerLastError, headerFailedQueue, headerFailedAt} {
+			delete(msg.Headers, header)
+		}
+
+		err = pub.publish("logs_topic", originalRoutingKey(d), msg)
+		if err != nil {
+			_ = d.Nack(false, true)
+			return replayed, err
+		}
+
+		if err = d.Ack(false); err != nil {
+			return replayed, err
+		}
+
+		replayed++
+	}
+
+	return replayed, nil
+}
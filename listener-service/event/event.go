@@ -1,9 +1,28 @@
 package event
 
 import (
+	"strings"
+	"time"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// Topic families published on the logs_topic exchange. Routing keys take the
+// form <family>.<SEVERITY>, e.g. log.ERROR or auth.WARNING.
+const (
+	FamilyLog  = "log"
+	FamilyAuth = "auth"
+	FamilyMail = "mail"
+)
+
+// Severities used as the second segment of a routing key
+const (
+	SeverityDebug   = "DEBUG"
+	SeverityInfo    = "INFO"
+	SeverityWarning = "WARNING"
+	SeverityError   = "ERROR"
+)
+
 func declareExchange(ch *amqp.Channel) error {
 	return ch.ExchangeDeclare(
 		"logs_topic", // name
@@ -26,3 +45,114 @@ func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
 		nil,   // arguments
 	)
 }
+
+// declareDurableQueue declares a named queue that survives broker and listener
+// restarts. Every listener declaring the same name shares it, so replicas
+// compete for deliveries instead of each receiving a copy.
+func declareDurableQueue(ch *amqp.Channel, name string) (amqp.Queue, error) {
+	return ch.QueueDeclare(
+		name,  // name
+		true,  // durable?
+		false, // delete when unused?
+		false, // exclusive?
+		false, // no-wait?
+		nil,   // arguments
+	)
+}
+
+// Exchanges and queues used to delay failed messages and to park the ones that
+// keep failing until someone inspects or replays them
+const (
+	retryExchange   = "logs_retry"
+	deadExchange    = "logs_dead"
+	DeadLetterQueue = "logs_dead_letter"
+)
+
+// declareRetryQueue declares a queue that holds failed messages for delay and
+// then dead-letters them back onto the work queue through the default exchange
+func declareRetryQueue(ch *amqp.Channel, workQueue string, delay time.Duration, exclusive bool) (amqp.Queue, error) {
+	err := ch.ExchangeDeclare(
+		retryExchange, // name
+		"direct",      // type
+		true,          // durable?
+		false,         // auto-deleted?
+		false,         // internal?
+		false,         // no-wait?
+		nil,           // arguments
+	)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	q, err := ch.QueueDeclare(
+		"retry."+workQueue, // name
+		!exclusive,         // durable?
+		false,              // delete when unused?
+		exclusive,          // exclusive?
+		false,              // no-wait?
+		amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": workQueue,
+		},
+	)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	err = ch.QueueBind(
+		q.Name,        // queue name
+		workQueue,     // routing key
+		retryExchange, // exchange
+		false,
+		nil,
+	)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	return q, nil
+}
+
+// declareDeadLetterQueue declares the durable queue that collects messages
+// which exhausted their retries or could not be decoded at all
+func declareDeadLetterQueue(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(
+		deadExchange, // name
+		"fanout",     // type
+		true,         // durable?
+		false,        // auto-deleted?
+		false,        // internal?
+		false,        // no-wait?
+		nil,          // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(
+		DeadLetterQueue, // name
+		true,            // durable?
+		false,           // delete when unused?
+		false,           // exclusive?
+		false,           // no-wait?
+		nil,             // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(
+		DeadLetterQueue, // queue name
+		"",              // routing key
+		deadExchange,    // exchange
+		false,
+		nil,
+	)
+}
+
+// SplitRoutingKey returns the family and severity segments of a routing key
+func SplitRoutingKey(key string) (family, severity string) {
+	family, severity, _ = strings.Cut(key, ".")
+	return family, severity
+}
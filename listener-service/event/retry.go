@@ -0,0 +1,128 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers carried by messages that have been retried or dead-lettered
+const (
+	headerRetryCount  = "x-retry-count"
+	headerRoutingKey  = "x-original-routing-key"
+	headerLastError   = "x-last-error"
+	headerFailedQueue = "x-failed-queue"
+	headerFailedAt    = "x-failed-at"
+)
+
+const publishTimeout = 5 * time.Second
+
+// errNacked means RabbitMQ refused responsibility for a message
+var errNacked = errors.New("message was not accepted by rabbitmq")
+
+// publisher serialises publishes from the worker goroutines onto one channel in
+// confirm mode, so a copy only counts as published once RabbitMQ has taken it
+type publisher struct {
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func newPublisher(ch *amqp.Channel) (*publisher, error) {
+	err := ch.Confirm(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &publisher{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+	}, nil
+}
+
+// publish sends msg and waits for RabbitMQ to confirm it
+func (p *publisher) publish(exchange, key string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	seq := p.ch.GetNextPublishSeqNo()
+
+	err := p.ch.PublishWithContext(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case confirm, ok := <-p.confirms:
+			if !ok {
+				return errors.New("channel closed before the message was confirmed")
+			}
+
+			// a late confirm for an earlier publish that timed out
+			if confirm.DeliveryTag < seq {
+				continue
+			}
+
+			if !confirm.Ack {
+				return errNacked
+			}
+
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for confirm: %w", ctx.Err())
+		}
+	}
+}
+
+// retryCount returns how many times a delivery has already been retried
+func retryCount(d amqp.Delivery) int {
+	switch n := d.Headers[headerRetryCount].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	default:
+		return 0
+	}
+}
+
+// originalRoutingKey returns the key a message was first published with. Retried
+// messages come back through the default exchange keyed by queue name, so the
+// original key travels in a header.
+func originalRoutingKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[headerRoutingKey].(string); ok && key != "" {
+		return key
+	}
+
+	return d.RoutingKey
+}
+
+// republish copies a delivery into a new publishing with extra headers
+func republish(d amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	merged := amqp.Table{}
+	for k, v := range d.Headers {
+		merged[k] = v
+	}
+	for k, v := range headers {
+		merged[k] = v
+	}
+
+	return amqp.Publishing{
+		Headers:      merged,
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    d.MessageId,
+		Timestamp:    d.Timestamp,
+		Body:         d.Body,
+	}
+}
@@ -2,4 +2,15 @@ module listener
 
 go 1.19
 
-require github.com/rabbitmq/amqp091-go v1.5.0 // indirect
+require github.com/rabbitmq/amqp091-go v1.5.0
+
+require (
+	config v0.0.0
+	rabbit v0.0.0
+)
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
+
+replace config => ../config
+
+replace rabbit => ../rabbit
@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log"
 	"logger/data"
 	"logger/logs"
 	"net"
 
+	"go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type LogServer struct {
@@ -16,16 +23,13 @@ type LogServer struct {
 }
 
 func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.LogResponse, error) {
-	input := req.GetLogEntry()
-
-	logEntry := data.LogEntry{
-		Name: input.Name,
-		Data: input.Data,
-	}
+	logEntry := fromProto(req.GetLogEntry())
 
 	err := l.Models.LogEntry.Insert(logEntry)
 
-	if err != nil {
+	if errors.Is(err, data.ErrInvalidSeverity) {
+		return &logs.LogResponse{Result: "failed"}, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
 		res := &logs.LogResponse{Result: "failed"}
 		return res, err
 	}
@@ -33,18 +37,177 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 	return &logs.LogResponse{Result: "successfully logged"}, nil
 }
 
-func (app *Config) gRPCListen() {
-	lis, err := net.Listen("tcp", "0.0.0.0:"+gRPCPort)
+func (l *LogServer) ListLogs(ctx context.Context, req *logs.ListLogsRequest) (*logs.ListLogsResponse, error) {
+	filter := data.LogFilter{
+		Name:     req.GetName(),
+		Severity: req.GetSeverity(),
+		Service:  req.GetService(),
+		TraceID:  req.GetTraceId(),
+		UserID:   req.GetUserId(),
+		Text:     req.GetQuery(),
+		Cursor:   req.GetCursor(),
+		Limit:    int(req.GetLimit()),
+	}
+
+	if req.GetFrom() != nil {
+		filter.From = req.GetFrom().AsTime()
+	}
+	if req.GetTo() != nil {
+		filter.To = req.GetTo().AsTime()
+	}
+
+	entries, next, err := l.Models.LogEntry.Find(filter)
+	if errors.Is(err, data.ErrInvalidCursor) || errors.Is(err, data.ErrInvalidSeverity) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	res := &logs.ListLogsResponse{NextCursor: next}
+	for _, entry := range entries {
+		res.Logs = append(res.Logs, toProto(entry))
+	}
+
+	return res, nil
+}
+
+func (l *LogServer) GetLog(ctx context.Context, req *logs.GetLogRequest) (*logs.Log, error) {
+	entry, err := l.Models.LogEntry.FindOne(req.GetId())
+
+	if errors.Is(err, data.ErrInvalidID) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, status.Error(codes.NotFound, "log entry not found")
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return toProto(entry), nil
+}
+
+// WriteLogs ingests a client stream of entries, reporting how many were stored once the client closes the stream
+func (l *LogServer) WriteLogs(stream logs.LogService_WriteLogsServer) error {
+	var written, failed int32
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&logs.WriteLogsResponse{
+				Written: written,
+				Failed:  failed,
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		err = l.Models.LogEntry.Insert(fromProto(req.GetLogEntry()))
+		if err != nil {
+			failed++
+			continue
+		}
+
+		written++
+	}
+}
+
+// TailLogs streams entries to the client as they are inserted, optionally only those with a given name
+func (l *LogServer) TailLogs(req *logs.TailLogsRequest, stream logs.LogService_TailLogsServer) error {
+	entries, cancel := l.Models.LogEntry.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case entry := <-entries:
+			if req.GetName() != "" && entry.Name != req.GetName() {
+				continue
+			}
+
+			if err := stream.Send(toProto(&entry)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func toProto(entry *data.LogEntry) *logs.Log {
+	l := &logs.Log{
+		Id:        entry.ID,
+		Name:      entry.Name,
+		Data:      entry.Data,
+		Severity:  entry.Severity,
+		Service:   entry.Service,
+		TraceId:   entry.TraceID,
+		UserId:    entry.UserID,
+		CreatedAt: timestamppb.New(entry.CreatedAt),
+		UpdatedAt: timestamppb.New(entry.UpdatedAt),
+	}
+
+	if len(entry.Fields) > 0 {
+		fields, err := structpb.NewStruct(entry.Fields)
+		if err != nil {
+			log.Println("could not convert fields for entry", entry.ID, err)
+		} else {
+			l.Fields = fields
+		}
+	}
+
+	return l
+}
+
+func fromProto(input *logs.Log) data.LogEntry {
+	return data.LogEntry{
+		Name:     input.GetName(),
+		Data:     input.GetData(),
+		Severity: input.GetSeverity(),
+		Service:  input.GetService(),
+		TraceID:  input.GetTraceId(),
+		UserID:   input.GetUserId(),
+		Fields:   input.GetFields().AsMap(),
+	}
+}
+
+// grpcServer is the LogService gRPC server bound to its listener
+type grpcServer struct {
+	server   *grpc.Server
+	listener net.Listener
+}
+
+func (app *Config) gRPCListen() (*grpcServer, error) {
+	lis, err := net.Listen("tcp", "0.0.0.0:"+app.Settings.GRPCPort)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	s := grpc.NewServer()
 
 	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models})
 
-	log.Println("Starting gRPC server on port: ", gRPCPort)
-	if err := s.Serve(lis); err != nil {
-		log.Fatal(err)
+	return &grpcServer{server: s, listener: lis}, nil
+}
+
+func (s *grpcServer) serve() error {
+	log.Println("Starting gRPC server on: ", s.listener.Addr())
+
+	return s.server.Serve(s.listener)
+}
+
+// shutdown stops the server gracefully, waiting for pending calls. TailLogs streams
+// never finish on their own, so anything still running when ctx is done is cut off.
+func (s *grpcServer) shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
 	}
 }
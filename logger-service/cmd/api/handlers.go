@@ -1,29 +1,59 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"logger/data"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string         `json:"name"`
+	Data     string         `json:"data"`
+	Severity string         `json:"severity,omitempty"`
+	Service  string         `json:"service,omitempty"`
+	TraceID  string         `json:"trace_id,omitempty"`
+	UserID   int64          `json:"user_id,omitempty"`
+	Fields   map[string]any `json:"fields,omitempty"`
+	// EventID makes writing the entry idempotent: a second entry with the same id
+	// is ignored
+	EventID string `json:"event_id,omitempty"`
 }
 
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	var requestPayload JSONPayload
-	_ = app.readJSON(w, r, &requestPayload)
+
+	err := app.readJSON(w, r, &requestPayload)
+
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		Name:     requestPayload.Name,
+		Data:     requestPayload.Data,
+		Severity: requestPayload.Severity,
+		Service:  requestPayload.Service,
+		TraceID:  requestPayload.TraceID,
+		UserID:   requestPayload.UserID,
+		Fields:   requestPayload.Fields,
+		EventID:  requestPayload.EventID,
 	}
 
-	err := app.Models.LogEntry.Insert(event)
+	err = app.Models.LogEntry.Insert(event)
 
-	if err != nil {
+	if errors.Is(err, data.ErrInvalidSeverity) {
 		app.errorJSON(w, err)
 		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
 
 	resp := jsonResponse{
@@ -33,3 +63,100 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	app.writeJSON(w, http.StatusCreated, resp, nil)
 }
+
+// ListLogs returns a page of log entries, newest first. Supported query parameters
+// are name, severity, service, trace_id, user_id, from and to (RFC 3339), q (full-text
+// search on data), limit and cursor.
+func (app *Config) ListLogs(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	filter := data.LogFilter{
+		Name:     qs.Get("name"),
+		Severity: qs.Get("severity"),
+		Service:  qs.Get("service"),
+		TraceID:  qs.Get("trace_id"),
+		Text:     qs.Get("q"),
+		Cursor:   qs.Get("cursor"),
+	}
+
+	var err error
+
+	if userID := qs.Get("user_id"); userID != "" {
+		filter.UserID, err = strconv.ParseInt(userID, 10, 64)
+		if err != nil {
+			app.errorJSON(w, errors.New("user_id must be an integer"))
+			return
+		}
+	}
+
+	if filter.From, err = readTime(qs.Get("from")); err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid from: %w", err))
+		return
+	}
+
+	if filter.To, err = readTime(qs.Get("to")); err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid to: %w", err))
+		return
+	}
+
+	if limit := qs.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > data.MaxPageSize {
+			app.errorJSON(w, fmt.Errorf("limit must be between 1 and %d", data.MaxPageSize))
+			return
+		}
+	}
+
+	entries, next, err := app.Models.LogEntry.Find(filter)
+
+	if errors.Is(err, data.ErrInvalidCursor) || errors.Is(err, data.ErrInvalidSeverity) {
+		app.errorJSON(w, err)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "Log entries",
+		Data: map[string]any{
+			"logs":        entries,
+			"next_cursor": next,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// GetLog returns a single log entry by id
+func (app *Config) GetLog(w http.ResponseWriter, r *http.Request) {
+	entry, err := app.Models.LogEntry.FindOne(chi.URLParam(r, "id"))
+
+	if errors.Is(err, data.ErrInvalidID) {
+		app.errorJSON(w, err)
+		return
+	} else if errors.Is(err, mongo.ErrNoDocuments) {
+		app.errorJSON(w, errors.New("log entry not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: "Log entry",
+		Data:    entry,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func readTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
@@ -2,97 +2,234 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"logger/data"
 	"net"
 	"net/http"
 	"net/rpc"
-	"time"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-const (
-	webPort  = "80"
-	rpcPort  = "5001"
-	mongoURL = "mongodb://mongo:27017"
-	gRPCPort = "50001"
-)
-
 var client *mongo.Client
 
 type Config struct {
-	Models data.Models
+	Settings Settings
+	Models   data.Models
 }
 
 func main() {
-	mongoClient, err := connectToMongo()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	settings, err := loadSettings()
 	if err != nil {
 		log.Fatal(err)
 	}
-	client = mongoClient
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
 
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			panic(err)
-		}
-	}()
+	mongoClient, err := connectToMongo(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client = mongoClient
 
 	app := Config{
-		Models: data.New(client),
+		Settings: settings,
+		Models:   data.New(client),
 	}
 
-	err = rpc.Register(new(RPCServer))
-	go app.rpcListen()
+	err = app.Models.LogEntry.EnsureIndexes()
+	if err != nil {
+		log.Println("could not create indexes:", err)
+	}
 
-	go app.gRPCListen()
+	err = rpc.Register(&RPCServer{Models: app.Models})
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	app.serve()
-}
+	rpcSrv, err := app.rpcListen()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	grpcSrv, err := app.gRPCListen()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-func (app *Config) serve() {
 	srv := &http.Server{
-		Addr:    ":" + webPort,
+		Addr:    ":" + settings.Port,
 		Handler: app.routes(),
 	}
 
-	log.Println("Starting server on port", webPort)
+	errs := make(chan error, 3)
 
-	err := srv.ListenAndServe()
-	if err != nil {
-		log.Fatal(err)
+	go func() {
+		log.Println("Starting server on port", settings.Port)
+		errs <- srv.ListenAndServe()
+	}()
+
+	go func() {
+		errs <- rpcSrv.serve()
+	}()
+
+	go func() {
+		errs <- grpcSrv.serve()
+	}()
+
+	var failed error
+
+	select {
+	case failed = <-errs:
+		log.Println("server stopped:", failed)
+	case <-ctx.Done():
+		log.Println("shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+	defer cancel()
+
+	// stop taking new work on every port, then let what is in flight finish
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Println("could not drain http requests:", err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		if err := rpcSrv.shutdown(shutdownCtx); err != nil {
+			log.Println("could not drain rpc connections:", err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		if err := grpcSrv.shutdown(shutdownCtx); err != nil {
+			log.Println("could not drain grpc calls:", err)
+		}
+	}()
+
+	wg.Wait()
+
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		log.Println("could not disconnect from mongo:", err)
+	}
+
+	// a server that could not start, such as on a port already in use, is fatal
+	if failed != nil && !errors.Is(failed, http.ErrServerClosed) {
+		log.Fatal(failed)
 	}
 }
 
-func (app *Config) rpcListen() {
-	log.Println("Starting RPC server on port: ", rpcPort)
+// rpcServer serves net/rpc and keeps track of open connections so the calls in
+// flight on them can be drained on shutdown
+type rpcServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
 
-	listen, err := net.Listen("tcp", "0.0.0.0:"+rpcPort)
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (app *Config) rpcListen() (*rpcServer, error) {
+	listen, err := net.Listen("tcp", "0.0.0.0:"+app.Settings.RPCPort)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	defer listen.Close()
+	return &rpcServer{
+		listener: listen,
+		conns:    map[net.Conn]struct{}{},
+	}, nil
+}
+
+func (s *rpcServer) serve() error {
+	log.Println("Starting RPC server on: ", s.listener.Addr())
 
 	for {
-		rpcConn, err := listen.Accept()
-		if err != nil {
-			log.Fatal(err)
+		rpcConn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[rpcConn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			rpc.ServeConn(rpcConn)
+
+			s.mu.Lock()
+			delete(s.conns, rpcConn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// shutdown stops accepting connections and stops reading calls on the open
+// ones, which net/rpc closes once the calls in flight have been answered.
+// Clients such as the broker keep their connection open for as long as they
+// run, so it doesn't wait for them to hang up. Any connection still open when
+// ctx is done is closed.
+func (s *rpcServer) shutdown(ctx context.Context) error {
+	err := s.listener.Close()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseRead()
+		} else {
+			conn.Close()
 		}
+	}
+	s.mu.Unlock()
 
-		go rpc.ServeConn(rpcConn)
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
 	}
+	s.mu.Unlock()
+
+	return ctx.Err()
 }
 
-func connectToMongo() (*mongo.Client, error) {
-	clientOptions := options.Client().ApplyURI(mongoURL)
+func connectToMongo(settings Settings) (*mongo.Client, error) {
+	clientOptions := options.Client().ApplyURI(settings.MongoURL)
 	clientOptions.SetAuth(options.Credential{
-		Username: "admin",
-		Password: "password",
+		Username: settings.MongoUsername,
+		Password: settings.MongoPassword,
 	})
 	c, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
@@ -23,6 +23,8 @@ func (app *Config) routes() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping"))
 
 	mux.Post("/log", app.WriteLog)
+	mux.Get("/logs", app.ListLogs)
+	mux.Get("/logs/{id}", app.GetLog)
 
 	return mux
 }
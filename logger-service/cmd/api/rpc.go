@@ -1,28 +1,50 @@
 package main
 
 import (
-	"context"
+	"encoding/gob"
+	"errors"
 	"logger/data"
-	"time"
 )
 
-type RPCServer struct{}
+// rpcInvalidEntry starts the error returned for entries that fail validation.
+// net/rpc only carries error text, so callers use it to tell a rejected entry
+// from a failure on our side.
+const rpcInvalidEntry = "invalid entry: "
+
+type RPCServer struct {
+	Models data.Models
+}
 
 type RPCPayload struct {
-	Name string
-	Data string
+	Name     string
+	Data     string
+	Severity string
+	Service  string
+	TraceID  string
+	UserID   int64
+	Fields   map[string]any
 }
 
-func (r *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
-	collection := client.Database("logs").Collection("logs")
+func init() {
+	// nested field values arrive as interface values, so gob needs to know their concrete types
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+}
 
-	_, err := collection.InsertOne(context.TODO(), data.LogEntry{
-		Name:      payload.Name,
-		Data:      payload.Data,
-		CreatedAt: time.Now(),
+func (r *RPCServer) LogInfo(payload RPCPayload, resp *string) error {
+	err := r.Models.LogEntry.Insert(data.LogEntry{
+		Name:     payload.Name,
+		Data:     payload.Data,
+		Severity: payload.Severity,
+		Service:  payload.Service,
+		TraceID:  payload.TraceID,
+		UserID:   payload.UserID,
+		Fields:   payload.Fields,
 	})
 
-	if err != nil {
+	if errors.Is(err, data.ErrInvalidSeverity) {
+		return errors.New(rpcInvalidEntry + err.Error())
+	} else if err != nil {
 		return err
 	}
 
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"config"
+	"time"
+)
+
+// Settings are read from the environment and the optional YAML file named by
+// CONFIG_FILE. The defaults match the docker-compose stack.
+type Settings struct {
+	Port            string        `yaml:"port" env:"PORT" default:"80"`
+	RPCPort         string        `yaml:"rpc_port" env:"RPC_PORT" default:"5001"`
+	GRPCPort        string        `yaml:"grpc_port" env:"GRPC_PORT" default:"50001"`
+	MongoURL        string        `yaml:"mongo_url" env:"MONGO_URL" default:"mongodb://mongo:27017" validate:"url"`
+	MongoUsername   string        `yaml:"mongo_username" env:"MONGO_USERNAME" default:"admin"`
+	MongoPassword   string        `yaml:"mongo_password" env:"MONGO_PASSWORD" default:"password"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"8s" validate:"positive"`
+}
+
+func loadSettings() (Settings, error) {
+	var settings Settings
+
+	err := config.Load(&settings)
+
+	return settings, err
+}
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Severity levels accepted for a log entry. They double as the suffix of the
+// log.* routing keys on the logs_topic exchange.
+const (
+	SeverityDebug   = "DEBUG"
+	SeverityInfo    = "INFO"
+	SeverityWarning = "WARNING"
+	SeverityError   = "ERROR"
+)
+
+var ErrInvalidSeverity = errors.New("severity must be one of DEBUG, INFO, WARNING or ERROR")
+
+// NormalizeSeverity upper-cases a severity, defaulting an empty one to INFO
+func NormalizeSeverity(severity string) (string, error) {
+	if severity == "" {
+		return SeverityInfo, nil
+	}
+
+	severity = strings.ToUpper(severity)
+
+	switch severity {
+	case SeverityDebug, SeverityInfo, SeverityWarning, SeverityError:
+		return severity, nil
+	case "WARN":
+		return SeverityWarning, nil
+	default:
+		return "", ErrInvalidSeverity
+	}
+}
+
+// normalizeFields turns the BSON types the driver produces for nested documents
+// and arrays back into plain maps and slices, so fields encode the same way they
+// were written whether they go out as JSON or as a protobuf Struct
+func normalizeFields(fields map[string]any) map[string]any {
+	if fields == nil {
+		return nil
+	}
+
+	out := make(map[string]any, len(fields))
+	for k, v := range fields {
+		out[k] = normalizeValue(v)
+	}
+
+	return out
+}
+
+func normalizeValue(v any) any {
+	switch val := v.(type) {
+	case primitive.D:
+		m := make(map[string]any, len(val))
+		for _, e := range val {
+			m[e.Key] = normalizeValue(e.Value)
+		}
+		return m
+	case primitive.M:
+		return normalizeFields(val)
+	case map[string]any:
+		return normalizeFields(val)
+	case primitive.A:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = normalizeValue(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			out[i] = normalizeValue(item)
+		}
+		return out
+	case primitive.DateTime:
+		return val.Time().UTC().Format(time.RFC3339Nano)
+	case primitive.ObjectID:
+		return val.Hex()
+	default:
+		return val
+	}
+}
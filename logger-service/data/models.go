@@ -18,11 +18,17 @@ type Models struct {
 }
 
 type LogEntry struct {
-	ID        string    `json:"id" bson:"_id"`
-	Name      string    `json:"name" bson:"name"`
-	Data      string    `json:"data" bson:"data"`
-	CreatedAt time.Time `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	ID        string         `json:"id" bson:"_id"`
+	Name      string         `json:"name" bson:"name"`
+	Data      string         `json:"data" bson:"data"`
+	Severity  string         `json:"severity" bson:"severity"`
+	Service   string         `json:"service,omitempty" bson:"service,omitempty"`
+	TraceID   string         `json:"trace_id,omitempty" bson:"trace_id,omitempty"`
+	UserID    int64          `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Fields    map[string]any `json:"fields,omitempty" bson:"fields,omitempty"`
+	EventID   string         `json:"event_id,omitempty" bson:"event_id,omitempty"`
+	CreatedAt time.Time      `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at" bson:"updated_at"`
 }
 
 func New(mongo *mongo.Client) Models {
@@ -33,18 +39,54 @@ func New(mongo *mongo.Client) Models {
 }
 
 func (l *LogEntry) Insert(entry LogEntry) error {
+	severity, err := NormalizeSeverity(entry.Severity)
+	if err != nil {
+		return err
+	}
+
 	collection := client.Database("logs").Collection("logs")
-	_, err := collection.InsertOne(context.TODO(), LogEntry{
+	now := time.Now()
+	doc := LogEntry{
 		ID:        primitive.NewObjectID().Hex(),
 		Name:      entry.Name,
 		Data:      entry.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
+		Severity:  severity,
+		Service:   entry.Service,
+		TraceID:   entry.TraceID,
+		UserID:    entry.UserID,
+		Fields:    entry.Fields,
+		EventID:   entry.EventID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if doc.EventID != "" {
+		return insertOnce(collection, doc)
+	}
+
+	_, err = collection.InsertOne(context.TODO(), doc)
 	if err != nil {
 		log.Println("Error Inserting:", err.Error())
 		return err
 	}
+	publish(doc)
+	return nil
+}
+
+// insertOnce writes doc unless an entry with the same event id is already
+// stored, so an event that is delivered again is only logged once
+func insertOnce(collection *mongo.Collection, doc LogEntry) error {
+	opts := options.Update().SetUpsert(true)
+
+	result, err := collection.UpdateOne(context.TODO(), bson.M{"event_id": doc.EventID}, bson.M{"$setOnInsert": doc}, opts)
+	if err != nil {
+		log.Println("Error Inserting:", err.Error())
+		return err
+	}
+
+	if result.UpsertedCount > 0 {
+		publish(doc)
+	}
 	return nil
 }
 
@@ -54,7 +96,7 @@ func (l *LogEntry) FindAll() ([]*LogEntry, error) {
 	defer cancel()
 
 	opts := options.Find()
-	opts.SetSort(bson.D{{"created_at", -1}})
+	opts.SetSort(bson.D{{Key: "created_at", Value: -1}})
 
 	cursor, err := collection.Find(context.TODO(), bson.D{}, opts)
 
@@ -72,6 +114,7 @@ func (l *LogEntry) FindAll() ([]*LogEntry, error) {
 			log.Println("Error Decoding:", err.Error())
 			return nil, err
 		}
+		entry.Fields = normalizeFields(entry.Fields)
 		results = append(results, &entry)
 	}
 	return results, nil
@@ -82,18 +125,20 @@ func (l *LogEntry) FindOne(id string) (*LogEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	docID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
+	// ids are stored as the hex form of an ObjectID, so validate the shape but
+	// query by the string itself
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
 		log.Println("Error Finding:", err.Error())
-		return nil, err
+		return nil, ErrInvalidID
 	}
 
 	var entry LogEntry
-	err = collection.FindOne(ctx, bson.M{"_id": docID}).Decode(&entry)
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&entry)
 	if err != nil {
 		log.Println("Error Finding:", err.Error())
 		return nil, err
 	}
+	entry.Fields = normalizeFields(entry.Fields)
 	return &entry, nil
 }
 
@@ -116,21 +161,20 @@ func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	docID, err := primitive.ObjectIDFromHex(l.ID)
-	if err != nil {
-		log.Println("Error Finding:", err.Error())
-		return nil, err
-	}
-
 	update := bson.D{{
-		"$set", bson.D{
-			{"name", l.Name},
-			{"data", l.Data},
-			{"updated_at", time.Now()},
+		Key: "$set", Value: bson.D{
+			{Key: "name", Value: l.Name},
+			{Key: "data", Value: l.Data},
+			{Key: "severity", Value: l.Severity},
+			{Key: "service", Value: l.Service},
+			{Key: "trace_id", Value: l.TraceID},
+			{Key: "user_id", Value: l.UserID},
+			{Key: "fields", Value: l.Fields},
+			{Key: "updated_at", Value: time.Now()},
 		},
 	}}
 
-	res, err := collection.UpdateOne(ctx, bson.M{"_id": docID}, update)
+	res, err := collection.UpdateOne(ctx, bson.M{"_id": l.ID}, update)
 	if err != nil {
 		log.Println("Error Updating:", err.Error())
 		return nil, err
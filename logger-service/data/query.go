@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mongopage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultPageSize = mongopage.DefaultSize
+	MaxPageSize     = mongopage.MaxSize
+)
+
+var (
+	ErrInvalidID     = errors.New("invalid log id")
+	ErrInvalidCursor = mongopage.ErrInvalidCursor
+)
+
+// LogFilter narrows a query over the logs collection. Zero values are ignored.
+type LogFilter struct {
+	Name     string
+	Severity string
+	Service  string
+	TraceID  string
+	UserID   int64
+	From     time.Time
+	To       time.Time
+	Text     string
+	Cursor   string
+	Limit    int
+}
+
+// EnsureIndexes creates the indexes the query API relies on. It is safe to call on every start.
+func (l *LogEntry) EnsureIndexes() error {
+	collection := client.Database("logs").Collection("logs")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "severity", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "service", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "trace_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "data", Value: "text"}}},
+		{
+			Keys:    bson.D{{Key: "event_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"event_id": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		log.Println("Error Creating Indexes:", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Find returns one page of entries matching filter, newest first, along with the
+// cursor for the next page. The cursor is empty when there are no more results.
+func (l *LogEntry) Find(filter LogFilter) ([]*LogEntry, string, error) {
+	collection := client.Database("logs").Collection("logs")
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter.Limit = mongopage.Limit(filter.Limit)
+
+	query, err := filter.query()
+	if err != nil {
+		return nil, "", err
+	}
+
+	cursor, err := collection.Find(ctx, query, mongopage.Options(filter.Limit))
+	if err != nil {
+		log.Println("Error Finding:", err.Error())
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*LogEntry{}
+	for cursor.Next(ctx) {
+		var entry LogEntry
+		err := cursor.Decode(&entry)
+		if err != nil {
+			log.Println("Error Decoding:", err.Error())
+			return nil, "", err
+		}
+		entry.Fields = normalizeFields(entry.Fields)
+		results = append(results, &entry)
+	}
+
+	if err := cursor.Err(); err != nil {
+		log.Println("Error Finding:", err.Error())
+		return nil, "", err
+	}
+
+	results, next := mongopage.Page(results, filter.Limit, func(entry *LogEntry) (time.Time, string) {
+		return entry.CreatedAt, entry.ID
+	})
+
+	return results, next, nil
+}
+
+func (f LogFilter) query() (bson.D, error) {
+	query := bson.D{}
+
+	if f.Name != "" {
+		query = append(query, bson.E{Key: "name", Value: f.Name})
+	}
+
+	if f.Severity != "" {
+		severity, err := NormalizeSeverity(f.Severity)
+		if err != nil {
+			return nil, err
+		}
+		query = append(query, bson.E{Key: "severity", Value: severity})
+	}
+
+	if f.Service != "" {
+		query = append(query, bson.E{Key: "service", Value: f.Service})
+	}
+
+	if f.TraceID != "" {
+		query = append(query, bson.E{Key: "trace_id", Value: f.TraceID})
+	}
+
+	if f.UserID != 0 {
+		query = append(query, bson.E{Key: "user_id", Value: f.UserID})
+	}
+
+	createdAt := bson.D{}
+	if !f.From.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: f.From})
+	}
+	if !f.To.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$lte", Value: f.To})
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "created_at", Value: createdAt})
+	}
+
+	if f.Text != "" {
+		query = append(query, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: f.Text}}})
+	}
+
+	return mongopage.After(query, f.Cursor)
+}
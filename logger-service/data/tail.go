@@ -0,0 +1,50 @@
+package data
+
+import (
+	"log"
+	"sync"
+)
+
+// tailBuffer is how many entries a subscriber may fall behind before entries are dropped for it
+const tailBuffer = 64
+
+var subscribers = struct {
+	sync.Mutex
+	chans map[chan LogEntry]struct{}
+}{chans: make(map[chan LogEntry]struct{})}
+
+// Subscribe returns a channel that receives every entry inserted by this process after the
+// call, and a function that must be called to stop the subscription
+func (l *LogEntry) Subscribe() (<-chan LogEntry, func()) {
+	ch := make(chan LogEntry, tailBuffer)
+
+	subscribers.Lock()
+	subscribers.chans[ch] = struct{}{}
+	subscribers.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			subscribers.Lock()
+			delete(subscribers.chans, ch)
+			subscribers.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, cancel
+}
+
+// publish fans an inserted entry out to subscribers without blocking the writer
+func publish(entry LogEntry) {
+	subscribers.Lock()
+	defer subscribers.Unlock()
+
+	for ch := range subscribers.chans {
+		select {
+		case ch <- entry:
+		default:
+			log.Println("Tail subscriber is falling behind, dropping entry", entry.ID)
+		}
+	}
+}
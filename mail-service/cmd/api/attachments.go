@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"mailmsg"
+	"os"
+	"path/filepath"
+)
+
+// stageAttachments writes attachments to a new temporary directory so they can
+// be sent from disk. cleanup removes the directory and must be called once the
+// mail has been sent, whether or not sending succeeded.
+func stageAttachments(list []mailmsg.Attachment) (files []Attachment, cleanup func(), err error) {
+	cleanup = func() {}
+
+	if len(list) == 0 {
+		return nil, cleanup, nil
+	}
+
+	dir, err := os.MkdirTemp("", "mail-attachments-")
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	cleanup = func() {
+		os.RemoveAll(dir)
+	}
+
+	for i, a := range list {
+		// prefixed with the index so attachments with the same name don't collide
+		path := filepath.Join(dir, fmt.Sprintf("%d-%s", i, a.Filename))
+
+		err = os.WriteFile(path, a.Content, 0600)
+		if err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+
+		files = append(files, Attachment{
+			Path:        path,
+			Name:        a.Filename,
+			ContentType: a.DetectContentType(),
+			Inline:      a.Inline,
+		})
+	}
+
+	return files, cleanup, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Delivery backends, picked with MAIL_BACKEND
+const (
+	backendSMTP    = "smtp"
+	backendOutbox  = "outbox"
+	backendCapture = "capture"
+)
+
+// Envelope is a fully built message and who it is delivered to
+type Envelope struct {
+	ID         string // the message's tracking id
+	From       string
+	Recipients []string // every To, Cc and Bcc address
+	Subject    string
+	Raw        string // the RFC 822 message
+}
+
+// Backend delivers built messages
+type Backend interface {
+	Deliver(ctx context.Context, env Envelope) error
+	Close()
+}
+
+// newBackend returns the backend named in settings
+func newBackend(settings MailSettings) (Backend, error) {
+	switch settings.Backend {
+	case backendSMTP:
+		return newSMTPBackend(settings), nil
+	case backendOutbox:
+		return newOutboxBackend(settings.OutboxDir)
+	case backendCapture:
+		return newCaptureBackend(settings.CaptureLimit), nil
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", settings.Backend)
+	}
+}
+
+// smtpBackend sends through the SMTP relay over a pool of keep-alive connections
+type smtpBackend struct {
+	pool *smtpPool
+}
+
+func newSMTPBackend(settings MailSettings) *smtpBackend {
+	server := mail.NewSMTPClient()
+	server.Host = settings.Host
+	server.Port = settings.Port
+	server.Username = settings.Username
+	server.Password = settings.Password
+	server.Encryption = getEncryption(settings.Encryption)
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	return &smtpBackend{
+		pool: newSMTPPool(server, settings.PoolSize, settings.IdleTimeout),
+	}
+}
+
+func (b *smtpBackend) Deliver(ctx context.Context, env Envelope) error {
+	conn, err := b.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = mail.SendMessage(env.From, env.Recipients, env.Raw, conn.client)
+	b.pool.put(conn, err)
+
+	return err
+}
+
+func (b *smtpBackend) Close() {
+	b.pool.Close()
+}
+
+// outboxBackend writes each message as an .eml file into a maildir, so local
+// runs can open sent mail in any mail client. Files are written to tmp/ and
+// moved into new/ once complete.
+type outboxBackend struct {
+	dir string
+}
+
+func newOutboxBackend(dir string) (*outboxBackend, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &outboxBackend{dir: dir}, nil
+}
+
+func (b *outboxBackend) Deliver(ctx context.Context, env Envelope) error {
+	name := fmt.Sprintf("%d.%s.eml", time.Now().UnixNano(), env.ID)
+	tmp := filepath.Join(b.dir, "tmp", name)
+
+	err := os.WriteFile(tmp, []byte(env.Raw), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, filepath.Join(b.dir, "new", name))
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+func (b *outboxBackend) Close() {}
+
+// CapturedMessage is a message held by the capture backend
+type CapturedMessage struct {
+	ID         string    `json:"id"`
+	From       string    `json:"from"`
+	Recipients []string  `json:"recipients"`
+	Subject    string    `json:"subject"`
+	Raw        string    `json:"raw"`
+	CapturedAt time.Time `json:"captured_at"`
+}
+
+// CaptureBackend keeps delivered messages in memory instead of sending them, so
+// tests and local runs can check what was sent. Only the latest limit messages
+// are kept.
+type CaptureBackend struct {
+	limit int
+
+	mu       sync.Mutex
+	messages []CapturedMessage
+}
+
+func newCaptureBackend(limit int) *CaptureBackend {
+	return &CaptureBackend{limit: limit}
+}
+
+func (b *CaptureBackend) Deliver(ctx context.Context, env Envelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.messages = append(b.messages, CapturedMessage{
+		ID:         env.ID,
+		From:       env.From,
+		Recipients: env.Recipients,
+		Subject:    env.Subject,
+		Raw:        env.Raw,
+		CapturedAt: time.Now(),
+	})
+
+	if len(b.messages) > b.limit {
+		b.messages = b.messages[len(b.messages)-b.limit:]
+	}
+
+	return nil
+}
+
+// Messages returns the captured messages, oldest first
+func (b *CaptureBackend) Messages() []CapturedMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]CapturedMessage(nil), b.messages...)
+}
+
+// Clear forgets every captured message
+func (b *CaptureBackend) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.messages = nil
+}
+
+func (b *CaptureBackend) Close() {}
@@ -1,41 +1,190 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mail-service/data"
+	"mail-service/event"
+	"mailmsg"
+	"net/http"
+	"strings"
+)
 
-func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
-	type mailMessage struct {
-		From    string `json:"from"`
-		To      string `json:"to"`
-		Subject string `json:"subject"`
-		Message string `json:"message"`
+// mailMessage is a mail request, sent directly to /send or queued by the broker
+type mailMessage struct {
+	mailmsg.Addressing
+	Subject  string         `json:"subject"`
+	Message  string         `json:"message"`
+	Template string         `json:"template,omitempty"`
+	Version  int            `json:"version,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+
+	Attachments []mailmsg.Attachment `json:"attachments,omitempty"`
+}
+
+// validate checks the addressing and attachments, returning an
+// *mailmsg.InvalidError that lists each problem found
+func (m mailMessage) validate(limits mailmsg.Limits) error {
+	return mailmsg.Validate(m.Addressing, m.Attachments, limits)
+}
+
+func (m mailMessage) message() Message {
+	return Message{
+		From:     m.From,
+		To:       m.To,
+		Cc:       m.Cc,
+		Bcc:      m.Bcc,
+		ReplyTo:  m.ReplyTo,
+		Headers:  m.Headers,
+		Priority: m.Priority,
+		Subject:  m.Subject,
+		Data:     m.Message,
+		DataMap:  m.Data,
+		Template: m.Template,
+		Version:  m.Version,
+	}
+}
+
+// send stages m's attachments on disk, sends it as message id and removes them
+// again
+func (app *Config) send(ctx context.Context, id string, m mailMessage) error {
+	files, cleanup, err := stageAttachments(m.Attachments)
+	if err != nil {
+		return err
 	}
 
+	defer cleanup()
+
+	msg := m.message()
+	msg.ID = id
+	msg.Attachments = files
+
+	return app.Mailer.SendSMTPMessage(ctx, msg)
+}
+
+func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 	var requestPayload mailMessage
 
-	err := app.readJSON(w, r, &requestPayload)
+	err := app.readJSONLimit(w, r, &requestPayload, app.Attachments.RequestBytes())
 
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	msg := Message{
-		From:    requestPayload.From,
-		To:      requestPayload.To,
-		Subject: requestPayload.Subject,
-		Data:    requestPayload.Message,
+	err = requestPayload.validate(app.Attachments)
+	if err != nil {
+		app.invalidMail(w, err)
+		return
+	}
+
+	id, err := newMessageID()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
 
-	err = app.Mailer.SendSMTPMessage(msg)
+	// kept so that a failed attempt can be queued for a retry
+	body, err := json.Marshal(requestPayload)
 	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.recordMessage(requestPayload.record(id))
+
+	result := app.track(r.Context(), event.Job{ID: id, Body: body, Attempt: 1}, requestPayload)
+	recipients := strings.Join(requestPayload.Recipients(), ", ")
+
+	switch result.Status {
+	case data.StatusSent:
+		app.writeJSON(w, http.StatusAccepted, jsonResponse{
+			Error:   false,
+			Message: "Mail sent successfully to " + recipients,
+			Data:    result,
+		})
+	case data.StatusRetrying:
+		app.writeJSON(w, http.StatusAccepted, jsonResponse{
+			Error:   false,
+			Message: "Mail to " + recipients + " could not be sent yet and will be retried",
+			Data:    result,
+		})
+	default:
+		app.writeJSON(w, failureStatus(result.cause), jsonResponse{
+			Error:   true,
+			Message: result.Error,
+			Data:    result,
+		})
+	}
+}
+
+// failureStatus picks the status for a failed send. The relay being down, or a
+// retry that couldn't be scheduled, is a 503 and a relay refusing the message a
+// 502; anything else, such as a template that wouldn't render, is the caller's.
+func failureStatus(err error) int {
+	var failed *DeliveryError
+	if !errors.As(err, &failed) {
+		return http.StatusBadRequest
+	}
+
+	if failed.Temporary() {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusBadGateway
+}
+
+// invalidMail answers a mail request that failed validation, listing each problem
+func (app *Config) invalidMail(w http.ResponseWriter, err error) {
+	var invalid *mailmsg.InvalidError
+	if !errors.As(err, &invalid) {
 		app.errorJSON(w, err)
 		return
 	}
 
+	payload := jsonResponse{
+		Error:   true,
+		Message: err.Error(),
+		Data:    invalid.Problems,
+	}
+
+	app.writeJSON(w, http.StatusBadRequest, payload)
+}
+
+// Templates lists the registered templates and their versions
+func (app *Config) Templates(w http.ResponseWriter, r *http.Request) {
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Templates",
+		Data:    app.Mailer.templates.List(),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CapturedMessages lists the messages held by the capture backend
+func (app *Config) CapturedMessages(w http.ResponseWriter, r *http.Request) {
+	messages := app.Captured.Messages()
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d captured messages", len(messages)),
+		Data:    messages,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ClearCaptured forgets the messages held by the capture backend
+func (app *Config) ClearCaptured(w http.ResponseWriter, r *http.Request) {
+	app.Captured.Clear()
+
 	payload := jsonResponse{
 		Error:   false,
-		Message: "Mail sent successfully to " + requestPayload.To,
+		Message: "Captured messages cleared",
 	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	app.writeJSON(w, http.StatusOK, payload)
 }
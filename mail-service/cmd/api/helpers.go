@@ -15,9 +15,12 @@ type jsonResponse struct {
 
 // read JSON from request body
 func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, v any) error {
-	maxBytes := 1048576
+	return app.readJSONLimit(w, r, v, 1048576)
+}
 
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+// read JSON from a request body of up to maxBytes
+func (app *Config) readJSONLimit(w http.ResponseWriter, r *http.Request, v any, maxBytes int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
 	dec := json.NewDecoder(r.Body)
 	err := dec.Decode(v)
 
@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
-	"html/template"
-	"time"
+	"context"
+	"errors"
+	"mailmsg"
+	"net/textproto"
 
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
@@ -11,26 +13,71 @@ import (
 
 type Mail struct {
 	Domain      string
-	Host        string
-	Port        int
-	Username    string
-	Password    string
-	Encryption  string
 	FromAddress string
 	FromName    string
+
+	backend   Backend
+	templates *TemplateRegistry
+}
+
+// NewMail parses the mail templates once and delivers mail through backend
+func NewMail(m Mail, backend Backend) (*Mail, error) {
+	var err error
+
+	m.templates, err = LoadTemplates("./templates")
+	if err != nil {
+		return nil, err
+	}
+
+	m.backend = backend
+
+	return &m, nil
+}
+
+// Close releases the delivery backend
+func (m *Mail) Close() {
+	m.backend.Close()
 }
 
 type Message struct {
+	// ID is the message's tracking id, which backends that keep messages store
+	ID          string
 	From        string
 	FromName    string
-	To          string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	ReplyTo     string
+	Headers     map[string]string
+	Priority    string
 	Subject     string
-	Attachments []string
+	Attachments []Attachment
 	Data        any
 	DataMap     map[string]any
+	// Template and Version pick a registered template; the default layout is
+	// used when Template is empty and the latest version when Version is zero
+	Template string
+	Version  int
 }
 
-func (m *Mail) SendSMTPMessage(msg Message) error {
+// Attachment is a file on disk to send with a message. Inline attachments are
+// shown by the HTML body with src="cid:<Name>" rather than listed as files.
+type Attachment struct {
+	Path        string
+	Name        string
+	ContentType string
+	Inline      bool
+}
+
+func (m *Mail) SendSMTPMessage(ctx context.Context, msg Message) error {
+	if msg.ID == "" {
+		id, err := newMessageID()
+		if err != nil {
+			return err
+		}
+		msg.ID = id
+	}
+
 	if msg.From == "" {
 		msg.From = m.FromAddress
 	}
@@ -38,69 +85,119 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 		msg.FromName = m.FromName
 	}
 
+	tmpl, err := m.templates.Lookup(msg.Template, msg.Version)
+	if err != nil {
+		return err
+	}
+
 	data := map[string]any{
 		"message": msg.Data,
 	}
 
+	for key, value := range msg.DataMap {
+		data[key] = value
+	}
+
 	msg.DataMap = data
 
-	formattedMessage, err := m.buildHTMLMessage(msg)
+	formattedMessage, err := m.buildHTMLMessage(tmpl, msg)
 	if err != nil {
 		return err
 	}
 
-	plainTxt, err := m.buildPlainTextMessage(msg)
+	plainTxt, err := m.buildPlainTextMessage(tmpl, msg)
 	if err != nil {
 		return err
 	}
 
-	server := mail.NewSMTPClient()
-	server.Host = m.Host
-	server.Port = m.Port
-	server.Username = m.Username
-	server.Password = m.Password
-	server.Encryption = m.getEncryption(m.Encryption)
-	server.KeepAlive = false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
+	email := mail.NewMSG()
+	email.SetFrom(msg.From).SetSubject(msg.Subject)
 
-	smtpClient, err := server.Connect()
-	if err != nil {
-		return err
+	if len(msg.To) > 0 {
+		email.AddTo(msg.To...)
+	}
+	if len(msg.Cc) > 0 {
+		email.AddCc(msg.Cc...)
+	}
+	if len(msg.Bcc) > 0 {
+		email.AddBcc(msg.Bcc...)
+	}
+	if msg.ReplyTo != "" {
+		email.SetReplyTo(msg.ReplyTo)
+	}
+
+	for name, value := range msg.Headers {
+		email.AddHeader(name, value)
+	}
+
+	switch msg.Priority {
+	case mailmsg.PriorityHigh:
+		email.SetPriority(mail.PriorityHigh)
+	case mailmsg.PriorityLow:
+		email.SetPriority(mail.PriorityLow)
 	}
 
-	email := mail.NewMSG()
-	email.SetFrom(msg.From).AddTo(msg.To).SetSubject(msg.Subject)
 	email.SetBody(mail.TextPlain, plainTxt)
 	email.AddAlternative(mail.TextHTML, formattedMessage)
 
-	if len(msg.Attachments) > 0 {
-		for _, attachment := range msg.Attachments {
-			email.AddAttachment(attachment)
-		}
+	for _, attachment := range msg.Attachments {
+		email.Attach(&mail.File{
+			FilePath: attachment.Path,
+			Name:     attachment.Name,
+			MimeType: attachment.ContentType,
+			Inline:   attachment.Inline,
+		})
+	}
+
+	if email.Error != nil {
+		return email.Error
 	}
 
-	err = email.Send(smtpClient)
+	err = m.backend.Deliver(ctx, Envelope{
+		ID:         msg.ID,
+		From:       email.GetFrom(),
+		Recipients: email.GetRecipients(),
+		Subject:    msg.Subject,
+		Raw:        email.GetMessage(),
+	})
 	if err != nil {
-		return err
+		return &DeliveryError{err}
 	}
 
 	return nil
 }
 
-func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
-	t, err := template.New("email-html").ParseFiles("./templates/mail.html.gohtml")
-	if err != nil {
-		return "", err
+// DeliveryError is a message that was built but could not be delivered
+type DeliveryError struct {
+	Err error
+}
+
+func (e *DeliveryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether delivering the message again later may succeed,
+// which is the case unless the server refused it with a permanent 5xx reply
+func (e *DeliveryError) Temporary() bool {
+	var reply *textproto.Error
+	if errors.As(e.Err, &reply) {
+		return reply.Code < 500
 	}
 
+	return true
+}
+
+func (m *Mail) buildHTMLMessage(tmpl mailTemplate, msg Message) (string, error) {
 	var tpl bytes.Buffer
-	if err = t.ExecuteTemplate(&tpl, "body", msg.DataMap); err != nil {
+	if err := tmpl.html.ExecuteTemplate(&tpl, "body", msg.DataMap); err != nil {
 		return "", err
 	}
 
-	formattedMessage := tpl.String()
-	formattedMessage, err = m.inlineCSS(formattedMessage)
+	formattedMessage, err := m.inlineCSS(tpl.String())
 	if err != nil {
 		return "", err
 	}
@@ -108,14 +205,9 @@ func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
 	return formattedMessage, nil
 }
 
-func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
-	t, err := template.New("email-plain").ParseFiles("./templates/mail.plain.gohtml")
-	if err != nil {
-		return "", err
-	}
-
+func (m *Mail) buildPlainTextMessage(tmpl mailTemplate, msg Message) (string, error) {
 	var tpl bytes.Buffer
-	if err = t.ExecuteTemplate(&tpl, "body", msg.DataMap); err != nil {
+	if err := tmpl.plain.ExecuteTemplate(&tpl, "body", msg.DataMap); err != nil {
 		return "", err
 	}
 
@@ -142,7 +234,7 @@ func (m *Mail) inlineCSS(s string) (string, error) {
 	return html, nil
 }
 
-func (m *Mail) getEncryption(encryption string) mail.Encryption {
+func getEncryption(encryption string) mail.Encryption {
 	switch encryption {
 	case "tls":
 		return mail.EncryptionSTARTTLS
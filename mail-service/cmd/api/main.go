@@ -1,43 +1,187 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"mail-service/data"
+	"mail-service/event"
+	"mailmsg"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"rabbit"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var client *mongo.Client
+
 type Config struct {
-	Mailer Mail
+	Mailer      *Mail
+	Models      data.Models
+	Retrier     *event.Retrier
+	Attachments mailmsg.Limits
+	Captured    *CaptureBackend
 }
 
-const webPort = "80"
-
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	settings, err := loadSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	backend, err := newBackend(settings.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mailer, err := createMail(settings.Mail, backend)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer mailer.Close()
+
+	mongoClient, err := connectToMongo(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client = mongoClient
+
 	app := Config{
-		Mailer: createMail(),
+		Mailer: mailer,
+		Models: data.New(client),
+		Attachments: mailmsg.Limits{
+			MaxSize:  settings.Mail.MaxAttachmentSize,
+			MaxTotal: settings.Mail.MaxAttachmentsTotal,
+		},
+	}
+
+	err = app.Models.Message.EnsureIndexes()
+	if err != nil {
+		log.Println("could not create indexes:", err)
 	}
 
-	log.Println("Starting server on port", webPort)
+	// captured mail can be listed over HTTP
+	if capture, ok := backend.(*CaptureBackend); ok {
+		app.Captured = capture
+	}
+
+	rabbitConn, err := rabbit.Dial(settings.Worker.RabbitURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer rabbitConn.Close()
+
+	app.Retrier, err = event.NewRetrier(rabbitConn, retryDelays(settings.Worker))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	worker, err := event.NewWorker(rabbitConn, settings.Worker.Workers, app.sendJob)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	workerDone := make(chan error, 1)
+	go func() {
+		workerDone <- worker.Listen(ctx)
+	}()
+
+	log.Println("Starting server on port", settings.Port)
 
 	srv := &http.Server{
-		Addr:    ":" + webPort,
+		Addr:    ":" + settings.Port,
 		Handler: app.routes(),
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var failed error
+
+	select {
+	case failed = <-serveErr:
+		log.Println("server stopped:", failed)
+	case failed = <-workerDone:
+		log.Println("mail worker stopped:", failed)
+		workerDone = nil
+	case <-ctx.Done():
+		log.Println("shutting down")
+	}
+
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+	defer cancel()
+
+	// wait for in-flight requests before closing what they use
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Println("could not drain http requests:", err)
+	}
+
+	// let jobs that are being sent finish; the rest stay queued
+	if workerDone != nil {
+		select {
+		case <-workerDone:
+		case <-shutdownCtx.Done():
+			log.Println("timed out waiting for mail jobs; they will be redelivered")
+		}
+	}
+
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		log.Println("could not disconnect from mongo:", err)
+	}
+
+	// a server that could not start, such as on a port already in use, or a
+	// worker that stopped on its own is fatal
+	if failed != nil && !errors.Is(failed, http.ErrServerClosed) {
+		log.Fatal(failed)
+	}
+}
+
+func createMail(settings MailSettings, backend Backend) (*Mail, error) {
+	return NewMail(Mail{
+		Domain:      settings.Domain,
+		FromAddress: settings.FromAddress,
+		FromName:    settings.FromName,
+	}, backend)
+}
+
+// retryDelays doubles the wait before each successive retry
+func retryDelays(settings WorkerSettings) []time.Duration {
+	delays := make([]time.Duration, settings.RetryAttempts)
+
+	delay := settings.RetryBaseDelay
+	for i := range delays {
+		delays[i] = delay
+		delay *= 2
+	}
+
+	return delays
 }
 
-func createMail() Mail {
-	port, _ := strconv.Atoi(os.Getenv("MAIL_PORT"))
-	return Mail{
-		Domain:      os.Getenv("MAIL_DOMAIN"),
-		Host:        os.Getenv("MAIL_HOST"),
-		Port:        port,
-		Username:    os.Getenv("MAIL_USERNAME"),
-		Password:    os.Getenv("MAIL_PASSWORD"),
-		Encryption:  os.Getenv("MAIL_ENCRYPTION"),
-		FromAddress: os.Getenv("FROM_ADDRESS"),
-		FromName:    os.Getenv("FROM_NAME"),
+func connectToMongo(settings Settings) (*mongo.Client, error) {
+	clientOptions := options.Client().ApplyURI(settings.MongoURL)
+	clientOptions.SetAuth(options.Credential{
+		Username: settings.MongoUsername,
+		Password: settings.MongoPassword,
+	})
+	c, err := mongo.Connect(context.TODO(), clientOptions)
+	if err != nil {
+		log.Println("Error Connecting:", err.Error())
+		return nil, err
 	}
+	return c, nil
 }
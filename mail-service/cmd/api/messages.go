@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mail-service/data"
+	"mail-service/event"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// delivery is the outcome of a tracked send
+type delivery struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// cause is why a failed delivery failed
+	cause error
+}
+
+// record returns the delivery record for a new message
+func (m mailMessage) record(id string) data.Message {
+	msg := data.Message{
+		ID:       id,
+		From:     m.From,
+		To:       m.To,
+		Cc:       m.Cc,
+		Bcc:      m.Bcc,
+		Subject:  m.Subject,
+		Template: m.Template,
+		Version:  m.Version,
+	}
+
+	for _, a := range m.Attachments {
+		msg.Attachments = append(msg.Attachments, a.Filename)
+	}
+
+	return msg
+}
+
+// track makes one attempt at sending m, recording each step in the message's
+// status. Transient failures are scheduled for a retry, which picks up again
+// in sendJob; anything else, or running out of retries, fails the message.
+func (app *Config) track(ctx context.Context, job event.Job, m mailMessage) delivery {
+	app.setStatus(job.ID, data.StatusChange{Status: data.StatusSending, Attempt: job.Attempt}, nil)
+
+	err := app.send(ctx, job.ID, m)
+	if err == nil {
+		app.setStatus(job.ID, data.StatusChange{Status: data.StatusSent, Attempt: job.Attempt}, nil)
+		return delivery{ID: job.ID, Status: data.StatusSent}
+	}
+
+	var failed *DeliveryError
+	if errors.As(err, &failed) && failed.Temporary() {
+		next, retryErr := app.Retrier.Retry(job)
+		if retryErr == nil {
+			app.setStatus(job.ID, data.StatusChange{Status: data.StatusRetrying, Attempt: job.Attempt, Error: err.Error()}, &next)
+			return delivery{ID: job.ID, Status: data.StatusRetrying, Error: err.Error()}
+		} else if !errors.Is(retryErr, event.ErrNoRetriesLeft) {
+			log.Printf("could not schedule retry of message %s: %v", job.ID, retryErr)
+		}
+	}
+
+	app.setStatus(job.ID, data.StatusChange{Status: data.StatusFailed, Attempt: job.Attempt, Error: err.Error()}, nil)
+
+	return delivery{ID: job.ID, Status: data.StatusFailed, Error: err.Error(), cause: err}
+}
+
+// setStatus records a status change. Tracking is best effort: mail is still sent
+// when the status store is unavailable.
+func (app *Config) setStatus(id string, change data.StatusChange, next *time.Time) {
+	err := app.Models.Message.SetStatus(id, change, next)
+	if err != nil {
+		log.Printf("could not record message %s as %s: %v", id, change.Status, err)
+	}
+}
+
+// sendJob sends a mail job taken off the queue, either queued by the broker or
+// scheduled for a retry
+func (app *Config) sendJob(ctx context.Context, job event.Job) error {
+	if job.ID == "" {
+		return errors.New("mail job has no id")
+	}
+
+	if app.alreadyHandled(job) {
+		log.Printf("mail job %s was already handled, skipping redelivery", job.ID)
+		return nil
+	}
+
+	var requestPayload mailMessage
+
+	err := json.Unmarshal(job.Body, &requestPayload)
+	if err != nil {
+		err = fmt.Errorf("undecodable mail job: %w", err)
+		app.recordMessage(data.Message{ID: job.ID})
+		app.setStatus(job.ID, data.StatusChange{Status: data.StatusFailed, Attempt: job.Attempt, Error: err.Error()}, nil)
+		return err
+	}
+
+	// retries are already recorded
+	if job.Attempt == 1 {
+		app.recordMessage(requestPayload.record(job.ID))
+	}
+
+	err = requestPayload.validate(app.Attachments)
+	if err != nil {
+		app.setStatus(job.ID, data.StatusChange{Status: data.StatusFailed, Attempt: job.Attempt, Error: err.Error()}, nil)
+		return err
+	}
+
+	result := app.track(ctx, job, requestPayload)
+	if result.Status == data.StatusFailed {
+		return errors.New(result.Error)
+	}
+
+	return nil
+}
+
+// alreadyHandled reports whether a redelivered job was dealt with before it
+// could be acked: its message was sent or failed, or this attempt already
+// scheduled a retry. Jobs are sent when the record can't be read.
+func (app *Config) alreadyHandled(job event.Job) bool {
+	msg, err := app.Models.Message.FindOne(job.ID)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("could not look up message %s: %v", job.ID, err)
+		}
+		return false
+	}
+
+	switch msg.Status {
+	case data.StatusSent, data.StatusFailed:
+		return true
+	case data.StatusRetrying:
+		return msg.Attempts >= job.Attempt
+	default:
+		return false
+	}
+}
+
+func (app *Config) recordMessage(msg data.Message) {
+	err := app.Models.Message.Insert(msg)
+	if err != nil {
+		log.Printf("could not record message %s: %v", msg.ID, err)
+	}
+}
+
+// RecordMessage records a message that has been queued for sending, so that its
+// status can be looked up before a worker picks it up. Attachment content isn't
+// needed and may be left out.
+func (app *Config) RecordMessage(w http.ResponseWriter, r *http.Request) {
+	var requestPayload mailMessage
+
+	err := app.readJSONLimit(w, r, &requestPayload, app.Attachments.RequestBytes())
+
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	err = app.Models.Message.Insert(requestPayload.record(id))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Message queued",
+		Data:    delivery{ID: id, Status: data.StatusQueued},
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// GetMessage reports the delivery status and history of a message
+func (app *Config) GetMessage(w http.ResponseWriter, r *http.Request) {
+	msg, err := app.Models.Message.FindOne(chi.URLParam(r, "id"))
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		app.errorJSON(w, errors.New("message not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Message " + msg.Status,
+		Data:    msg,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ListMessages returns a page of messages, newest first, optionally filtered by
+// status, recipient and creation time
+func (app *Config) ListMessages(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	filter := data.MessageFilter{
+		Status:    qs.Get("status"),
+		Recipient: qs.Get("recipient"),
+		Cursor:    qs.Get("cursor"),
+	}
+
+	var err error
+
+	if filter.From, err = readTime(qs.Get("from")); err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid from: %w", err))
+		return
+	}
+
+	if filter.To, err = readTime(qs.Get("to")); err != nil {
+		app.errorJSON(w, fmt.Errorf("invalid to: %w", err))
+		return
+	}
+
+	if limit := qs.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > data.MaxPageSize {
+			app.errorJSON(w, fmt.Errorf("limit must be between 1 and %d", data.MaxPageSize))
+			return
+		}
+	}
+
+	messages, next, err := app.Models.Message.Find(filter)
+
+	if errors.Is(err, data.ErrInvalidCursor) || errors.Is(err, data.ErrInvalidStatus) {
+		app.errorJSON(w, err)
+		return
+	} else if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Messages",
+		Data: map[string]any{
+			"messages":    messages,
+			"next_cursor": next,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func readTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// newMessageID returns a random 128-bit message id
+func newMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// smtpPool keeps up to size keep-alive connections to the SMTP relay open and
+// shares them between sends. Connections are checked with NOOP before reuse and
+// redialled when the relay has dropped them.
+type smtpPool struct {
+	server      *mail.SMTPServer
+	idleTimeout time.Duration
+	slots       chan struct{}    // one token per connection that may be open
+	idle        chan *pooledConn // connections waiting to be reused
+}
+
+type pooledConn struct {
+	client   *mail.SMTPClient
+	lastUsed time.Time
+}
+
+func newSMTPPool(server *mail.SMTPServer, size int, idleTimeout time.Duration) *smtpPool {
+	server.KeepAlive = true
+
+	p := &smtpPool{
+		server:      server,
+		idleTimeout: idleTimeout,
+		slots:       make(chan struct{}, size),
+		idle:        make(chan *pooledConn, size),
+	}
+
+	for i := 0; i < size; i++ {
+		p.slots <- struct{}{}
+	}
+
+	return p
+}
+
+// get returns a healthy connection, waiting until ctx is done if every
+// connection is in use. It must be handed back with put.
+func (p *smtpPool) get(ctx context.Context) (*pooledConn, error) {
+	select {
+	case <-p.slots:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case c := <-p.idle:
+		if time.Since(c.lastUsed) < p.idleTimeout && c.client.Noop() == nil {
+			return c, nil
+		}
+
+		// stale or dropped by the relay
+		c.client.Close()
+	default:
+	}
+
+	client, err := p.server.Connect()
+	if err != nil {
+		p.slots <- struct{}{}
+		return nil, err
+	}
+
+	return &pooledConn{client: client}, nil
+}
+
+// put hands c back after a send. Connections that failed are closed, since a
+// timed out send may still be using them.
+func (p *smtpPool) put(c *pooledConn, sendErr error) {
+	defer func() {
+		p.slots <- struct{}{}
+	}()
+
+	if sendErr != nil {
+		c.client.Close()
+		return
+	}
+
+	c.lastUsed = time.Now()
+
+	select {
+	case p.idle <- c:
+	default:
+		quit(c.client)
+	}
+}
+
+// Close says goodbye to the relay on every idle connection
+func (p *smtpPool) Close() {
+	for {
+		select {
+		case c := <-p.idle:
+			quit(c.client)
+		default:
+			return
+		}
+	}
+}
+
+// quit ends the SMTP session, dropping the connection if the relay doesn't answer
+func quit(client *mail.SMTPClient) {
+	if client.Quit() != nil {
+		client.Close()
+	}
+}
@@ -23,5 +23,14 @@ func (app *Config) routes() http.Handler {
 	mux.Use(middleware.Heartbeat("/ping"))
 
 	mux.Post("/send", app.SendMail)
+	mux.Get("/messages", app.ListMessages)
+	mux.Get("/messages/{id}", app.GetMessage)
+	mux.Put("/messages/{id}", app.RecordMessage)
+	mux.Get("/templates", app.Templates)
+
+	if app.Captured != nil {
+		mux.Get("/captured", app.CapturedMessages)
+		mux.Delete("/captured", app.ClearCaptured)
+	}
 	return mux
 }
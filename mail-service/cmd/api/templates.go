@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	texttemplate "text/template"
+)
+
+// ErrUnknownTemplate is returned for a template name or version that isn't registered
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// versionFile matches the files of one template version, e.g. v2.html.gohtml
+var versionFile = regexp.MustCompile(`^v(\d+)\.(html|plain)\.gohtml$`)
+
+// mailTemplate is one version of a named template: an HTML and a plain text body.
+// Only the HTML body is escaped; the plain text body is parsed with text/template
+// so that values such as URLs come out as they are.
+type mailTemplate struct {
+	Name    string
+	Version int
+	html    *template.Template
+	plain   *texttemplate.Template
+}
+
+// TemplateRegistry holds every version of every named template. The default
+// layout in mail.html.gohtml and mail.plain.gohtml is used when no name is given,
+// and every named template is parsed on top of it, so a template that only
+// defines "content" keeps the default layout. A version without a plain text
+// file falls back to the default plain layout.
+//
+// Named templates live in templates/<name>/v<N>.html.gohtml and
+// v<N>.plain.gohtml.
+type TemplateRegistry struct {
+	fallback  mailTemplate
+	templates map[string][]mailTemplate // sorted by version
+}
+
+// LoadTemplates parses the default layout and every named template under dir
+func LoadTemplates(dir string) (*TemplateRegistry, error) {
+	html, err := template.New("email-html").ParseFiles(filepath.Join(dir, "mail.html.gohtml"))
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := texttemplate.New("email-plain").ParseFiles(filepath.Join(dir, "mail.plain.gohtml"))
+	if err != nil {
+		return nil, err
+	}
+
+	registry := &TemplateRegistry{
+		fallback:  mailTemplate{html: html, plain: plain},
+		templates: make(map[string][]mailTemplate),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		versions, err := registry.loadVersions(filepath.Join(dir, entry.Name()), entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		if len(versions) > 0 {
+			registry.templates[entry.Name()] = versions
+		}
+	}
+
+	return registry, nil
+}
+
+// loadVersions parses every version of the template in dir over the default layout
+func (r *TemplateRegistry) loadVersions(dir, name string) ([]mailTemplate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[int]map[string]string)
+
+	for _, entry := range entries {
+		m := versionFile.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", entry.Name(), err)
+		}
+
+		if files[version] == nil {
+			files[version] = make(map[string]string)
+		}
+		files[version][m[2]] = filepath.Join(dir, entry.Name())
+	}
+
+	var versions []mailTemplate
+
+	for version, kinds := range files {
+		htmlFile, ok := kinds["html"]
+		if !ok {
+			return nil, fmt.Errorf("template %s v%d has no html file", name, version)
+		}
+
+		t := mailTemplate{Name: name, Version: version}
+
+		t.html, err = parseOver(r.fallback.html, htmlFile)
+		if err != nil {
+			return nil, err
+		}
+
+		t.plain = r.fallback.plain
+		if plainFile, ok := kinds["plain"]; ok {
+			t.plain, err = parsePlainOver(r.fallback.plain, plainFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		versions = append(versions, t)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	return versions, nil
+}
+
+// parseOver parses file into a copy of layout, so its definitions replace the layout's
+func parseOver(layout *template.Template, file string) (*template.Template, error) {
+	t, err := layout.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.ParseFiles(file)
+}
+
+// parsePlainOver is parseOver for plain text layouts
+func parsePlainOver(layout *texttemplate.Template, file string) (*texttemplate.Template, error) {
+	t, err := layout.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.ParseFiles(file)
+}
+
+// Lookup returns the given version of the named template, or its latest version
+// if version is zero. An empty name returns the default layout.
+func (r *TemplateRegistry) Lookup(name string, version int) (mailTemplate, error) {
+	if name == "" {
+		return r.fallback, nil
+	}
+
+	versions, ok := r.templates[name]
+	if !ok {
+		return mailTemplate{}, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+
+	for _, t := range versions {
+		if t.Version == version {
+			return t, nil
+		}
+	}
+
+	return mailTemplate{}, fmt.Errorf("%w %q version %d", ErrUnknownTemplate, name, version)
+}
+
+// templateInfo describes a registered template on GET /templates
+type templateInfo struct {
+	Name     string `json:"name"`
+	Versions []int  `json:"versions"`
+}
+
+// List returns every registered template and its versions, sorted by name
+func (r *TemplateRegistry) List() []templateInfo {
+	list := make([]templateInfo, 0, len(r.templates))
+
+	for name, versions := range r.templates {
+		info := templateInfo{Name: name}
+		for _, t := range versions {
+			info.Versions = append(info.Versions, t.Version)
+		}
+		list = append(list, info)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var client *mongo.Client
+
+// Message statuses. A message is queued when it is accepted, sending while an
+// attempt is in progress and retrying while it waits for the next attempt after
+// a transient failure. It ends up sent, or failed once the server refuses it or
+// the attempts run out.
+const (
+	StatusQueued   = "queued"
+	StatusSending  = "sending"
+	StatusSent     = "sent"
+	StatusFailed   = "failed"
+	StatusRetrying = "retrying"
+)
+
+type Models struct {
+	Message Message
+}
+
+// Message is the delivery record of one outgoing mail. Only addressing and
+// metadata are kept, not the body or attachments.
+type Message struct {
+	ID            string         `json:"id" bson:"_id"`
+	Status        string         `json:"status" bson:"status"`
+	From          string         `json:"from,omitempty" bson:"from,omitempty"`
+	To            []string       `json:"to,omitempty" bson:"to,omitempty"`
+	Cc            []string       `json:"cc,omitempty" bson:"cc,omitempty"`
+	Bcc           []string       `json:"bcc,omitempty" bson:"bcc,omitempty"`
+	Recipients    []string       `json:"-" bson:"recipients"`
+	Subject       string         `json:"subject" bson:"subject"`
+	Template      string         `json:"template,omitempty" bson:"template,omitempty"`
+	Version       int            `json:"version,omitempty" bson:"version,omitempty"`
+	Attachments   []string       `json:"attachments,omitempty" bson:"attachments,omitempty"`
+	Attempts      int            `json:"attempts" bson:"attempts"`
+	LastError     string         `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	NextAttemptAt *time.Time     `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	History       []StatusChange `json:"history" bson:"history"`
+	CreatedAt     time.Time      `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at" bson:"updated_at"`
+}
+
+// StatusChange is one step in a message's lifecycle
+type StatusChange struct {
+	Status  string    `json:"status" bson:"status"`
+	Attempt int       `json:"attempt,omitempty" bson:"attempt,omitempty"`
+	Error   string    `json:"error,omitempty" bson:"error,omitempty"`
+	At      time.Time `json:"at" bson:"at"`
+}
+
+func New(mongo *mongo.Client) Models {
+	client = mongo
+	return Models{
+		Message: Message{},
+	}
+}
+
+func collection() *mongo.Collection {
+	return client.Database("mail").Collection("messages")
+}
+
+// Insert records msg as queued. Inserting a message that is already recorded,
+// as happens when a queued job is redelivered, leaves the existing record alone.
+func (m *Message) Insert(msg Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	msg.Status = StatusQueued
+	msg.Recipients = append(append(append([]string{}, msg.To...), msg.Cc...), msg.Bcc...)
+	msg.History = []StatusChange{{Status: StatusQueued, At: now}}
+	msg.CreatedAt = now
+	msg.UpdatedAt = now
+
+	doc, err := toDocument(msg)
+	if err != nil {
+		return err
+	}
+
+	// the upsert takes the id from the filter
+	delete(doc, "_id")
+
+	_, err = collection().UpdateOne(ctx,
+		bson.M{"_id": msg.ID},
+		bson.M{"$setOnInsert": doc},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		log.Println("Error Inserting:", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SetStatus moves the message with the given id on to change.Status and adds
+// change to its history. next is when a retrying message is tried again.
+func (m *Message) SetStatus(id string, change StatusChange, next *time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	change.At = time.Now()
+
+	set := bson.M{
+		"status":     change.Status,
+		"updated_at": change.At,
+	}
+
+	if change.Attempt > 0 {
+		set["attempts"] = change.Attempt
+	}
+
+	if change.Error != "" {
+		set["last_error"] = change.Error
+	}
+
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"history": change},
+	}
+
+	if next != nil {
+		set["next_attempt_at"] = next
+	} else {
+		update["$unset"] = bson.M{"next_attempt_at": ""}
+	}
+
+	result, err := collection().UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		log.Println("Error Updating:", err.Error())
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// FindOne returns the message with the given id
+func (m *Message) FindOne(id string) (*Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var msg Message
+	err := collection().FindOne(ctx, bson.M{"_id": id}).Decode(&msg)
+	if err != nil {
+		log.Println("Error Finding:", err.Error())
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+func toDocument(v any) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	err = bson.Unmarshal(raw, &doc)
+
+	return doc, err
+}
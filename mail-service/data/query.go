@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mongopage"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	DefaultPageSize = mongopage.DefaultSize
+	MaxPageSize     = mongopage.MaxSize
+)
+
+var (
+	ErrInvalidCursor = mongopage.ErrInvalidCursor
+	ErrInvalidStatus = errors.New("invalid status")
+)
+
+// MessageFilter narrows a query over the messages collection. Zero values are ignored.
+type MessageFilter struct {
+	Status    string
+	Recipient string
+	From      time.Time
+	To        time.Time
+	Cursor    string
+	Limit     int
+}
+
+// EnsureIndexes creates the indexes the query API relies on. It is safe to call on every start.
+func (m *Message) EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	_, err := collection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "recipients", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		log.Println("Error Creating Indexes:", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Find returns one page of messages matching filter, newest first, along with the
+// cursor for the next page. The cursor is empty when there are no more results.
+func (m *Message) Find(filter MessageFilter) ([]*Message, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	filter.Limit = mongopage.Limit(filter.Limit)
+
+	query, err := filter.query()
+	if err != nil {
+		return nil, "", err
+	}
+
+	cursor, err := collection().Find(ctx, query, mongopage.Options(filter.Limit))
+	if err != nil {
+		log.Println("Error Finding:", err.Error())
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	results := []*Message{}
+	for cursor.Next(ctx) {
+		var msg Message
+		err := cursor.Decode(&msg)
+		if err != nil {
+			log.Println("Error Decoding:", err.Error())
+			return nil, "", err
+		}
+		results = append(results, &msg)
+	}
+
+	if err := cursor.Err(); err != nil {
+		log.Println("Error Finding:", err.Error())
+		return nil, "", err
+	}
+
+	results, next := mongopage.Page(results, filter.Limit, func(msg *Message) (time.Time, string) {
+		return msg.CreatedAt, msg.ID
+	})
+
+	return results, next, nil
+}
+
+func (f MessageFilter) query() (bson.D, error) {
+	query := bson.D{}
+
+	if f.Status != "" {
+		switch f.Status {
+		case StatusQueued, StatusSending, StatusSent, StatusFailed, StatusRetrying:
+		default:
+			return nil, fmt.Errorf("%w %q", ErrInvalidStatus, f.Status)
+		}
+		query = append(query, bson.E{Key: "status", Value: f.Status})
+	}
+
+	if f.Recipient != "" {
+		query = append(query, bson.E{Key: "recipients", Value: f.Recipient})
+	}
+
+	createdAt := bson.D{}
+	if !f.From.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: f.From})
+	}
+	if !f.To.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$lte", Value: f.To})
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "created_at", Value: createdAt})
+	}
+
+	return mongopage.After(query, f.Cursor)
+}
@@ -0,0 +1,147 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"rabbit"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// attemptHeader carries how many times a job has been tried before
+const attemptHeader = "x-attempt"
+
+// ErrNoRetriesLeft is returned by Retry once a job has used up its attempts
+var ErrNoRetriesLeft = errors.New("no retries left")
+
+// Retrier sends failed jobs back to the mail queue after a delay. Each retry
+// waits in its own queue whose TTL is the backoff for that attempt, then dead
+// letters back onto the mail exchange, so jobs with different delays never wait
+// behind each other.
+type Retrier struct {
+	conn   *rabbit.Connection
+	delays []time.Duration
+}
+
+// NewRetrier declares a retry queue for each delay, again on every reconnect.
+// A job is retried once per delay, in order, before it is given up on.
+func NewRetrier(conn *rabbit.Connection, delays []time.Duration) (*Retrier, error) {
+	r := &Retrier{conn: conn, delays: delays}
+
+	err := conn.OnConnect(r.setup)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// retryQueue names the queue a job waits in for delay. Queues are named after
+// their delay since a queue's TTL can't change once it is declared.
+func retryQueue(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", rabbit.MailQueue, delay.Milliseconds())
+}
+
+func (r *Retrier) setup(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	defer ch.Close()
+
+	err = rabbit.DeclareMailQueue(ch)
+	if err != nil {
+		return err
+	}
+
+	for _, delay := range r.delays {
+		_, err = ch.QueueDeclare(
+			retryQueue(delay), // name
+			true,              // durable?
+			false,             // delete when unused?
+			false,             // exclusive?
+			false,             // no-wait?
+			amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    rabbit.MailExchange,
+				"x-dead-letter-routing-key": rabbit.MailRoutingKey,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Retry schedules job for another attempt and returns when it will be made. It
+// returns ErrNoRetriesLeft if job.Attempt has used up every delay.
+func (r *Retrier) Retry(job Job) (time.Time, error) {
+	if job.Attempt < 1 || job.Attempt > len(r.delays) {
+		return time.Time{}, ErrNoRetriesLeft
+	}
+
+	delay := r.delays[job.Attempt-1]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	defer ch.Close()
+
+	err = ch.Confirm(false)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	err = ch.PublishWithContext(ctx,
+		"",                // default exchange, straight to the queue
+		retryQueue(delay), // routing key
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    job.ID,
+			Timestamp:    time.Now(),
+			Headers:      amqp.Table{attemptHeader: int32(job.Attempt + 1)},
+			Body:         job.Body,
+		},
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	select {
+	case confirm := <-confirms:
+		if !confirm.Ack {
+			return time.Time{}, errors.New("retry was not accepted by rabbitmq")
+		}
+	case <-ctx.Done():
+		return time.Time{}, fmt.Errorf("waiting for retry confirm: %w", ctx.Err())
+	}
+
+	return time.Now().Add(delay), nil
+}
+
+// attempt reads which attempt a delivery is, counting from one
+func attempt(d amqp.Delivery) int {
+	switch n := d.Headers[attemptHeader].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	default:
+		return 1
+	}
+}
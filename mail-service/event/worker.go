@@ -0,0 +1,159 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"log"
+	"rabbit"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// workerTag identifies the worker's subscription so it can be cancelled on shutdown
+const workerTag = "mail-worker"
+
+// Job is a mail job taken off the queue
+type Job struct {
+	ID   string
+	Body []byte
+	// Attempt counts the tries at sending the job, starting at one
+	Attempt int
+}
+
+// JobHandler sends a mail job. Jobs are acknowledged once it returns, whether or
+// not sending succeeded, so it must record failures and schedule any retry itself.
+type JobHandler func(ctx context.Context, job Job) error
+
+// Worker consumes mail jobs with a fixed number of goroutines
+type Worker struct {
+	conn    *rabbit.Connection
+	workers int
+	handle  JobHandler
+}
+
+// NewWorker declares the mail queue, again on every reconnect, and returns a
+// Worker that passes jobs to handle
+func NewWorker(conn *rabbit.Connection, workers int, handle JobHandler) (*Worker, error) {
+	err := conn.OnConnect(setupMailQueue)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Worker{
+		conn:    conn,
+		workers: workers,
+		handle:  handle,
+	}, nil
+}
+
+func setupMailQueue(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	defer channel.Close()
+
+	return rabbit.DeclareMailQueue(channel)
+}
+
+// Listen consumes jobs until ctx is done, resubscribing whenever the connection
+// is re-established. Jobs already taken are finished before it returns.
+func (w *Worker) Listen(ctx context.Context) error {
+	for {
+		err := w.conn.Wait(ctx)
+		if ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = w.consume(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		log.Println("stopped consuming mail jobs:", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (w *Worker) consume(ctx context.Context) error {
+	ch, err := w.conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	defer ch.Close()
+
+	err = ch.Qos(w.workers, 0, false)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(
+		rabbit.MailQueue, // queue
+		workerTag,        // consumer
+		false,            // auto-acknowledge
+		false,            // exclusive
+		false,            // no-local/internal
+		false,            // no-wait
+		nil,              // args
+	)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < w.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range msgs {
+				w.process(d)
+			}
+		}()
+	}
+
+	log.Println("Waiting for mail jobs on", rabbit.MailQueue)
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := ch.Cancel(workerTag, false); err != nil {
+				log.Println("could not cancel mail worker:", err)
+			}
+		case <-stopped:
+		}
+	}()
+
+	// unacked jobs are redelivered if the channel goes away first
+	wg.Wait()
+
+	return errors.New("delivery channel closed")
+}
+
+// process sends one job. It doesn't get the listen context: a send that has
+// started runs to the end on shutdown rather than being abandoned halfway.
+func (w *Worker) process(d amqp.Delivery) {
+	err := w.handle(context.Background(), Job{ID: d.MessageId, Body: d.Body, Attempt: attempt(d)})
+	if err != nil {
+		log.Printf("mail job %s failed: %v", d.MessageId, err)
+	}
+
+	err = d.Ack(false)
+	if err != nil {
+		log.Printf("could not ack mail job %s: %v", d.MessageId, err)
+	}
+}
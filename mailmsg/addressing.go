@@ -0,0 +1,196 @@
+// Package mailmsg holds the mail payload that the broker and mail-service both
+// accept, and the validation both apply to it.
+package mailmsg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// Mail priorities
+const (
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+	PriorityLow    = "low"
+)
+
+// reservedHeaders are set from the message itself and can't be overridden
+var reservedHeaders = map[string]bool{
+	"From":                      true,
+	"Sender":                    true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Reply-To":                  true,
+	"Return-Path":               true,
+	"Subject":                   true,
+	"Date":                      true,
+	"Message-Id":                true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"X-Priority":                true,
+	"X-Msmail-Priority":         true,
+	"Importance":                true,
+}
+
+// Addresses is a list of addresses that also accepts a single address string,
+// so payloads written before lists were supported keep working
+type Addresses []string
+
+func (l *Addresses) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*l = nil
+		if single != "" {
+			*l = Addresses{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return errors.New("addresses must be a string or a list of strings")
+	}
+
+	*l = list
+
+	return nil
+}
+
+// Addressing is who a mail goes to and how it is marked
+type Addressing struct {
+	From     string            `json:"from"`
+	To       Addresses         `json:"to"`
+	Cc       Addresses         `json:"cc,omitempty"`
+	Bcc      Addresses         `json:"bcc,omitempty"`
+	ReplyTo  string            `json:"reply_to,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Priority string            `json:"priority,omitempty"`
+}
+
+// Problem is one thing wrong with a mail
+type Problem struct {
+	Field   string `json:"field"`
+	Address string `json:"address,omitempty"`
+	Error   string `json:"error"`
+}
+
+// InvalidError lists every problem found in a mail
+type InvalidError struct {
+	Problems []Problem
+}
+
+func (e *InvalidError) Error() string {
+	var problems []string
+
+	for _, p := range e.Problems {
+		if p.Address != "" {
+			problems = append(problems, fmt.Sprintf("%s %q: %s", p.Field, p.Address, p.Error))
+		} else {
+			problems = append(problems, p.Field+": "+p.Error)
+		}
+	}
+
+	return "invalid mail: " + strings.Join(problems, "; ")
+}
+
+// Recipients returns every address the mail is delivered to
+func (a Addressing) Recipients() []string {
+	var all []string
+
+	all = append(all, a.To...)
+	all = append(all, a.Cc...)
+	all = append(all, a.Bcc...)
+
+	return all
+}
+
+// Validate checks every address, header and the priority, returning an
+// *InvalidError that lists each problem found
+func (a Addressing) Validate() error {
+	problems := a.problems()
+
+	if len(problems) > 0 {
+		return &InvalidError{Problems: problems}
+	}
+
+	return nil
+}
+
+func (a Addressing) problems() []Problem {
+	var problems []Problem
+
+	checkAddress := func(field, address string) {
+		if _, err := mail.ParseAddress(address); err != nil {
+			problems = append(problems, Problem{Field: field, Address: address, Error: strings.TrimPrefix(err.Error(), "mail: ")})
+		}
+	}
+
+	if a.From != "" {
+		checkAddress("from", a.From)
+	}
+
+	if a.ReplyTo != "" {
+		checkAddress("reply_to", a.ReplyTo)
+	}
+
+	for _, address := range a.To {
+		checkAddress("to", address)
+	}
+	for _, address := range a.Cc {
+		checkAddress("cc", address)
+	}
+	for _, address := range a.Bcc {
+		checkAddress("bcc", address)
+	}
+
+	if len(a.Recipients()) == 0 {
+		problems = append(problems, Problem{Field: "to", Error: "at least one recipient is required"})
+	}
+
+	names := make([]string, 0, len(a.Headers))
+	for name := range a.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := a.Headers[name]
+		field := "headers." + name
+
+		switch {
+		case name == "" || strings.ContainsAny(name, " :\r\n"):
+			problems = append(problems, Problem{Field: field, Error: "invalid header name"})
+		case reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)]:
+			problems = append(problems, Problem{Field: field, Error: "header is set by the mail service"})
+		case strings.ContainsAny(value, "\r\n"):
+			problems = append(problems, Problem{Field: field, Error: "header value must be a single line"})
+		}
+	}
+
+	switch a.Priority {
+	case "", PriorityNormal, PriorityHigh, PriorityLow:
+	default:
+		problems = append(problems, Problem{Field: "priority", Error: "must be normal, high or low"})
+	}
+
+	return problems
+}
+
+// Validate checks the addressing and attachments, returning an *InvalidError
+// that lists each problem found
+func Validate(a Addressing, attachments []Attachment, limits Limits) error {
+	problems := append(a.problems(), CheckAttachments(attachments, limits)...)
+
+	if len(problems) > 0 {
+		return &InvalidError{Problems: problems}
+	}
+
+	return nil
+}
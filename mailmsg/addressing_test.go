@@ -0,0 +1,87 @@
+package mailmsg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateRejectsHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name       string
+		addressing Addressing
+		field      string
+		wantErr    string
+	}{
+		{
+			name:       "CRLF in header value",
+			addressing: Addressing{To: Addresses{"to@example.com"}, Headers: map[string]string{"X-Campaign": "spring\r\nBcc: victim@example.com"}},
+			field:      "headers.X-Campaign",
+			wantErr:    "header value must be a single line",
+		},
+		{
+			name:       "bare LF in header value",
+			addressing: Addressing{To: Addresses{"to@example.com"}, Headers: map[string]string{"X-Campaign": "spring\nBcc: victim@example.com"}},
+			field:      "headers.X-Campaign",
+			wantErr:    "header value must be a single line",
+		},
+		{
+			name:       "CRLF in header name",
+			addressing: Addressing{To: Addresses{"to@example.com"}, Headers: map[string]string{"X-Campaign\r\nBcc": "victim@example.com"}},
+			field:      "headers.X-Campaign\r\nBcc",
+			wantErr:    "invalid header name",
+		},
+		{
+			name:       "colon in header name",
+			addressing: Addressing{To: Addresses{"to@example.com"}, Headers: map[string]string{"Bcc: victim@example.com\r\nX-A": "b"}},
+			field:      "headers.Bcc: victim@example.com\r\nX-A",
+			wantErr:    "invalid header name",
+		},
+		{
+			name:       "reserved header",
+			addressing: Addressing{To: Addresses{"to@example.com"}, Headers: map[string]string{"bcc": "victim@example.com"}},
+			field:      "headers.bcc",
+			wantErr:    "header is set by the mail service",
+		},
+		{
+			name:       "CRLF in recipient",
+			addressing: Addressing{To: Addresses{"to@example.com\r\nBcc: victim@example.com"}},
+			field:      "to",
+		},
+		{
+			name:       "CRLF in reply-to",
+			addressing: Addressing{To: Addresses{"to@example.com"}, ReplyTo: "reply@example.com\r\nBcc: victim@example.com"},
+			field:      "reply_to",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.addressing.Validate()
+
+			var invalid *InvalidError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Validate() = %v, want an *InvalidError", err)
+			}
+
+			for _, p := range invalid.Problems {
+				if p.Field == tt.field && (tt.wantErr == "" || p.Error == tt.wantErr) {
+					return
+				}
+			}
+
+			t.Errorf("problems = %+v, want one for %q", invalid.Problems, tt.field)
+		})
+	}
+}
+
+func TestValidateAcceptsCustomHeaders(t *testing.T) {
+	a := Addressing{
+		To:       Addresses{"to@example.com"},
+		Headers:  map[string]string{"X-Campaign": "spring sale", "List-Unsubscribe": "<mailto:unsubscribe@example.com>"},
+		Priority: PriorityHigh,
+	}
+
+	if err := a.Validate(); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+}
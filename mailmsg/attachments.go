@@ -0,0 +1,79 @@
+package mailmsg
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Attachment is a file sent with a mail. Content is base64 encoded in JSON.
+// Inline attachments are images an HTML template shows with src="cid:<filename>".
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Content     []byte `json:"content"`
+	Inline      bool   `json:"inline,omitempty"`
+}
+
+// Limits bound the size of each attachment and of all of a mail's attachments
+// together, in bytes
+type Limits struct {
+	MaxSize  int64
+	MaxTotal int64
+}
+
+// RequestBytes is the largest request body that can carry attachments within
+// the limits: their base64 encoding plus room for the rest of the payload
+func (l Limits) RequestBytes() int64 {
+	return (l.MaxTotal+2)/3*4 + 1048576
+}
+
+// CheckAttachments returns a problem for every attachment that is unnamed, empty
+// or too large, or for the lot if together they are too large
+func CheckAttachments(list []Attachment, limits Limits) []Problem {
+	var problems []Problem
+	var total int64
+
+	for i, a := range list {
+		field := fmt.Sprintf("attachments[%d]", i)
+		size := int64(len(a.Content))
+		total += size
+
+		switch {
+		case a.Filename == "" || a.Filename != filepath.Base(a.Filename) || a.Filename == "." || a.Filename == "..":
+			problems = append(problems, Problem{Field: field, Error: "filename must be a plain file name"})
+		case size == 0:
+			problems = append(problems, Problem{Field: field, Error: "content is empty"})
+		case size > limits.MaxSize:
+			problems = append(problems, Problem{Field: field, Error: fmt.Sprintf("is %d bytes, more than the %d allowed", size, limits.MaxSize)})
+		case a.Inline && !strings.HasPrefix(a.DetectContentType(), "image/"):
+			problems = append(problems, Problem{Field: field, Error: "inline attachments must be images"})
+		}
+	}
+
+	if total > limits.MaxTotal {
+		problems = append(problems, Problem{Field: "attachments", Error: fmt.Sprintf("total %d bytes, more than the %d allowed", total, limits.MaxTotal)})
+	}
+
+	return problems
+}
+
+// DetectContentType returns the declared content type, or else sniffs the
+// content, trusting the file extension when sniffing finds nothing specific
+func (a Attachment) DetectContentType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+
+	sniffed := http.DetectContentType(a.Content)
+
+	if sniffed == "application/octet-stream" || strings.HasPrefix(sniffed, "text/plain") {
+		if byExt := mime.TypeByExtension(filepath.Ext(a.Filename)); byExt != "" {
+			return byExt
+		}
+	}
+
+	return sniffed
+}
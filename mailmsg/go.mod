@@ -0,0 +1,3 @@
+module mailmsg
+
+go 1.19
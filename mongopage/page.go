@@ -0,0 +1,101 @@
+// Package mongopage pages through a MongoDB collection newest first. Documents
+// are ordered by created_at and then _id, which breaks ties between documents
+// created in the same millisecond, and a page's cursor picks up after its last
+// document.
+package mongopage
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page sizes
+const (
+	DefaultSize = 50
+	MaxSize     = 500
+)
+
+// ErrInvalidCursor is returned for a cursor that wasn't made by Page
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Limit returns limit, or DefaultSize if it isn't positive, capped at MaxSize
+func Limit(limit int) int {
+	if limit <= 0 {
+		return DefaultSize
+	}
+	if limit > MaxSize {
+		return MaxSize
+	}
+
+	return limit
+}
+
+// Options sorts newest first and fetches one document more than limit, so that
+// Page can tell whether there is another page
+func Options(limit int) *options.FindOptions {
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	opts.SetLimit(int64(limit + 1))
+
+	return opts
+}
+
+// After adds to query the condition for documents strictly older than the one
+// cursor was made from. An empty cursor leaves query as it is.
+func After(query bson.D, cursor string) (bson.D, error) {
+	if cursor == "" {
+		return query, nil
+	}
+
+	at, id, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(query, bson.E{Key: "$or", Value: bson.A{
+		bson.D{{Key: "created_at", Value: bson.D{{Key: "$lt", Value: at}}}},
+		bson.D{{Key: "created_at", Value: at}, {Key: "_id", Value: bson.D{{Key: "$lt", Value: id}}}},
+	}}), nil
+}
+
+// Page trims results, fetched with Options, to limit and returns the cursor for
+// the next page. The cursor is empty when there are no more results. key returns
+// a document's created_at and id.
+func Page[T any](results []T, limit int, key func(T) (time.Time, string)) ([]T, string) {
+	if len(results) <= limit {
+		return results, ""
+	}
+
+	results = results[:limit]
+
+	return results, encodeCursor(key(results[len(results)-1]))
+}
+
+func encodeCursor(at time.Time, id string) string {
+	raw := at.UTC().Format(time.RFC3339Nano) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	at, id, found := strings.Cut(string(raw), "|")
+	if !found || id == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	return t, id, nil
+}
@@ -0,0 +1,256 @@
+// Package rabbit holds the RabbitMQ plumbing the services share: a connection
+// that re-dials by itself, and the exchange and queue mail jobs travel on.
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// maxBackoff caps the wait between reconnection attempts
+const maxBackoff = 30 * time.Second
+
+var (
+	// ErrNotConnected is returned while the connection is being re-established
+	ErrNotConnected = errors.New("not connected to rabbitmq")
+	// ErrClosed is returned once Close has been called
+	ErrClosed = errors.New("rabbitmq connection closed")
+)
+
+// Connection is an AMQP connection that re-dials with backoff whenever it drops.
+// Hooks registered with OnConnect run against every new connection before it is
+// handed out, so exchanges and queues are declared again after a broker restart.
+type Connection struct {
+	url string
+
+	mu    sync.RWMutex
+	conn  *amqp.Connection
+	ready chan struct{} // closed while conn is usable
+	hooks []func(*amqp.Connection) error
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Dial connects to url, retrying a few times with backoff before giving up, and
+// then keeps the connection open in the background until Close is called
+func Dial(url string) (*Connection, error) {
+	c := &Connection{
+		url:   url,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	var counts int64
+	var backoff = 1 * time.Second
+
+	for {
+		conn, err := amqp.Dial(url)
+		if err == nil {
+			log.Println("connected to rabbitmq")
+			c.connected(conn)
+			break
+		}
+
+		log.Println("failed to connect to rabbitmq, retrying")
+		counts++
+
+		if counts > 5 {
+			log.Println("failed to connect to rabbitmq")
+			return nil, err
+		}
+
+		backoff = time.Duration(math.Pow(float64(counts), 2)) * time.Second
+		log.Println("backing off: ", backoff)
+		time.Sleep(backoff)
+	}
+
+	go c.watch()
+
+	return c, nil
+}
+
+// Open is Dial for services that must start without RabbitMQ. It returns
+// straight away and dials in the background until the connection is up; until
+// then Channel returns ErrNotConnected.
+func Open(url string) *Connection {
+	c := &Connection{
+		url:   url,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		conn, err := amqp.Dial(url)
+		if err == nil && c.connected(conn) {
+			log.Println("connected to rabbitmq")
+		} else {
+			if err != nil {
+				log.Println("failed to connect to rabbitmq, retrying in the background:", err)
+			}
+			if !c.redial() {
+				return
+			}
+		}
+
+		c.watch()
+	}()
+
+	return c
+}
+
+// OnConnect registers hook to run on every new connection. If the connection is
+// currently up, the hook also runs straight away and its error is returned.
+func (c *Connection) OnConnect(hook func(*amqp.Connection) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks = append(c.hooks, hook)
+
+	if c.conn == nil {
+		return nil
+	}
+
+	return hook(c.conn)
+}
+
+// Channel opens a channel on the current connection
+func (c *Connection) Channel() (*amqp.Channel, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	return c.conn.Channel()
+}
+
+// Wait blocks until the connection is up, ctx is done or the connection is closed
+func (c *Connection) Wait(ctx context.Context) error {
+	c.mu.RLock()
+	ready := c.ready
+	c.mu.RUnlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-c.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops reconnecting and closes the current connection
+func (c *Connection) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.conn != nil {
+			err = c.conn.Close()
+			c.conn = nil
+		}
+	})
+
+	return err
+}
+
+// connected runs the hooks against conn and, if they all succeed and Close hasn't
+// been called, makes it the current connection
+func (c *Connection) connected(conn *amqp.Connection) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		_ = conn.Close()
+		return false
+	default:
+	}
+
+	for _, hook := range c.hooks {
+		if err := hook(conn); err != nil {
+			log.Println("could not set up rabbitmq connection:", err)
+			_ = conn.Close()
+			return false
+		}
+	}
+
+	c.conn = conn
+	close(c.ready)
+
+	return true
+}
+
+// watch waits for the connection to drop and re-dials until it is back
+func (c *Connection) watch() {
+	for {
+		c.mu.RLock()
+		conn := c.conn
+		c.mu.RUnlock()
+
+		if conn == nil {
+			return
+		}
+
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		select {
+		case <-c.done:
+			return
+		case err := <-closed:
+			log.Println("lost connection to rabbitmq:", err)
+		}
+
+		c.mu.Lock()
+		c.conn = nil
+		c.ready = make(chan struct{})
+		c.mu.Unlock()
+
+		if !c.redial() {
+			return
+		}
+	}
+}
+
+// redial dials until a connection is up and set up, backing off between
+// attempts. It returns false once the connection has been closed.
+func (c *Connection) redial() bool {
+	backoff := time.Second
+
+	for {
+		select {
+		case <-c.done:
+			return false
+		case <-time.After(backoff):
+		}
+
+		conn, err := amqp.Dial(c.url)
+		if err == nil {
+			if c.connected(conn) {
+				log.Println("reconnected to rabbitmq")
+				return true
+			}
+		} else {
+			log.Println("failed to reconnect to rabbitmq:", err)
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		log.Println("backing off: ", backoff)
+	}
+}
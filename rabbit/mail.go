@@ -0,0 +1,42 @@
+package rabbit
+
+import amqp "github.com/rabbitmq/amqp091-go"
+
+// Mail jobs are queued on their own durable exchange and queue rather than on
+// logs_topic, so a job waits for mail-service even if it is down when the job is
+// queued. Messages carry the job id as their message id.
+const (
+	MailExchange   = "mail_direct"
+	MailQueue      = "mail_jobs"
+	MailRoutingKey = "mail.send"
+)
+
+// DeclareMailQueue declares the mail exchange and the queue jobs wait in
+func DeclareMailQueue(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(
+		MailExchange, // name
+		"direct",     // type
+		true,         // durable?
+		false,        // auto-deleted?
+		false,        // internal?
+		false,        // no-wait?
+		nil,          // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(
+		MailQueue, // name
+		true,      // durable?
+		false,     // delete when unused?
+		false,     // exclusive?
+		false,     // no-wait?
+		nil,       // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(MailQueue, MailRoutingKey, MailExchange, false, nil)
+}